@@ -84,3 +84,7 @@ func (b *MockBootloader) RemoveKernelAssets(s snap.PlaceInfo) error {
 	b.RemoveKernelAssetsCalls = append(b.RemoveKernelAssetsCalls, s)
 	return nil
 }
+
+func (b *MockBootloader) ManagedAssets() []string {
+	return []string{b.ConfigFile()}
+}
@@ -20,8 +20,11 @@
 package bootloader
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/snapcore/snapd/bootloader/androidbootenv"
 	"github.com/snapcore/snapd/dirs"
@@ -29,11 +32,44 @@ import (
 	"github.com/snapcore/snapd/snap"
 )
 
-type androidboot struct{}
+type androidboot struct {
+	rootdir string
+
+	// unpackKernel is set when the kernel and initrd images need to be
+	// available in the boot directory
+	unpackKernel bool
+}
+
+// AndroidBootOption customizes an androidboot bootloader object.
+type AndroidBootOption func(a *androidboot)
+
+// WithKernelUnpack makes the androidboot bootloader unpack the kernel
+// and initrd images of kernel snaps into its directory, by default they
+// are left in the snap.
+func WithKernelUnpack() AndroidBootOption {
+	return func(a *androidboot) {
+		a.unpackKernel = true
+	}
+}
+
+// androidbootEnvLock serializes access to the environment file, the
+// androidboot objects are created on demand so the lock cannot live in
+// them.
+var androidbootEnvLock sync.RWMutex
 
 // newAndroidboot creates a new Androidboot bootloader object
 func newAndroidBoot() Bootloader {
-	a := &androidboot{}
+	return NewAndroidBootWithRoot(dirs.GlobalRootDir)
+}
+
+// NewAndroidBootWithRoot creates a new Androidboot bootloader object
+// operating on the system mounted at the given root directory, or nil
+// if androidboot is not in use there.
+func NewAndroidBootWithRoot(rootdir string, opts ...AndroidBootOption) Bootloader {
+	a := &androidboot{rootdir: rootdir}
+	for _, opt := range opts {
+		opt(a)
+	}
 	if !osutil.FileExists(a.ConfigFile()) {
 		return nil
 	}
@@ -45,7 +81,7 @@ func (a *androidboot) Name() string {
 }
 
 func (a *androidboot) Dir() string {
-	return filepath.Join(dirs.GlobalRootDir, "/boot/androidboot")
+	return filepath.Join(a.rootdir, "/boot/androidboot")
 }
 
 func (a *androidboot) ConfigFile() string {
@@ -53,8 +89,12 @@ func (a *androidboot) ConfigFile() string {
 }
 
 func (a *androidboot) GetBootVars(names ...string) (map[string]string, error) {
+	androidbootEnvLock.RLock()
+	defer androidbootEnvLock.RUnlock()
+
 	env := androidbootenv.NewEnv(a.ConfigFile())
-	if err := env.Load(); err != nil {
+	err := env.LoadStrict()
+	if err != nil && err != androidbootenv.ErrEmptyEnvironment {
 		return nil, err
 	}
 
@@ -63,25 +103,105 @@ func (a *androidboot) GetBootVars(names ...string) (map[string]string, error) {
 		out[name] = env.Get(name)
 	}
 
-	return out, nil
+	// an empty environment is still returned, with all values unset
+	return out, err
 }
 
-func (a *androidboot) SetBootVars(values map[string]string) error {
+// GetBootVarsAll returns all the variables set in the environment. Like
+// GetBootVars, it returns androidbootenv.ErrEmptyEnvironment along with
+// the values when no variable is set.
+func (a *androidboot) GetBootVarsAll() (map[string]string, error) {
+	androidbootEnvLock.RLock()
+	defer androidbootEnvLock.RUnlock()
+
+	env := androidbootenv.NewEnv(a.ConfigFile())
+	err := env.LoadStrict()
+	if err != nil && err != androidbootenv.ErrEmptyEnvironment {
+		return nil, err
+	}
+
+	return env.All(), err
+}
+
+// validateBootVar checks that a boot variable can be stored in the
+// line based key=value environment file without corrupting it.
+func validateBootVar(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("cannot set boot variable with empty name")
+	}
+	if strings.HasPrefix(key, "#") {
+		return fmt.Errorf("cannot set boot variable %q: name cannot start with '#'", key)
+	}
+	if strings.ContainsAny(key, "=\n\r\x00") {
+		return fmt.Errorf("cannot set boot variable %q: name cannot contain '=', newlines or NUL bytes", key)
+	}
+	if strings.ContainsAny(value, "=\n\r\x00") {
+		return fmt.Errorf("cannot set boot variable %q to %q: value cannot contain '=', newlines or NUL bytes", key, value)
+	}
+	return nil
+}
+
+// loadWithBootVars loads the environment and applies the given values
+// on top of it, the caller must hold androidbootEnvLock.
+func (a *androidboot) loadWithBootVars(values map[string]string) (*androidbootenv.Env, error) {
+	// validate everything upfront so that nothing is written on error
+	for k, v := range values {
+		if err := validateBootVar(k, v); err != nil {
+			return nil, err
+		}
+	}
+
 	env := androidbootenv.NewEnv(a.ConfigFile())
 	if err := env.Load(); err != nil && !os.IsNotExist(err) {
-		return err
+		return nil, err
 	}
 	for k, v := range values {
 		env.Set(k, v)
 	}
+	return env, nil
+}
+
+func (a *androidboot) SetBootVars(values map[string]string) error {
+	// hold the lock across load and save so that concurrent updates
+	// are not lost, the file itself is replaced atomically by Save
+	androidbootEnvLock.Lock()
+	defer androidbootEnvLock.Unlock()
+
+	env, err := a.loadWithBootVars(values)
+	if err != nil {
+		return err
+	}
 	return env.Save()
 }
 
+// SetBootVarsDryRun returns the content the environment file would
+// have after SetBootVars with the given values, without writing it.
+func (a *androidboot) SetBootVarsDryRun(values map[string]string) ([]byte, error) {
+	androidbootEnvLock.RLock()
+	defer androidbootEnvLock.RUnlock()
+
+	env, err := a.loadWithBootVars(values)
+	if err != nil {
+		return nil, err
+	}
+	return env.Bytes(), nil
+}
+
 func (a *androidboot) ExtractKernelAssets(s *snap.Info, snapf snap.Container) error {
+	if a.unpackKernel {
+		return extractKernelAssetsToBootDir(a.Dir(), s, snapf)
+	}
 	return nil
-
 }
 
 func (a *androidboot) RemoveKernelAssets(s snap.PlaceInfo) error {
-	return nil
+	// the kernel image is not unpacked but other files may have
+	// been put into the per-revision directory
+	return removeKernelAssetsFromBootDir(a.Dir(), s)
+}
+
+// ManagedAssets returns the environment file and the per-revision
+// kernel directories in the boot directory.
+func (a *androidboot) ManagedAssets() []string {
+	return append([]string{a.ConfigFile()}, kernelAssetsDirsInBootDir(a.Dir())...)
 }
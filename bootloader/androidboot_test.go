@@ -20,11 +20,17 @@
 package bootloader_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/bootloader/androidbootenv"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
@@ -51,7 +57,7 @@ func (g *androidBootTestSuite) SetUpTest(c *C) {
 	dirs.SetRootDir(c.MkDir())
 
 	// the file needs to exist for androidboot object to be created
-	bootloader.MockAndroidBootFile(c, 0644)
+	bootloader.MockAndroidBootFile(c, dirs.GlobalRootDir, 0644)
 }
 
 func (g *androidBootTestSuite) TearDownTest(c *C) {
@@ -70,6 +76,44 @@ func (s *androidBootTestSuite) TestNewAndroidboot(c *C) {
 	c.Assert(a, NotNil)
 }
 
+func (s *androidBootTestSuite) TestConfigFile(c *C) {
+	rootdir := c.MkDir()
+	bootloader.MockAndroidBootFile(c, rootdir, 0600)
+
+	a := bootloader.NewAndroidBootWithRoot(rootdir)
+	c.Assert(a, NotNil)
+	// the path is absolute and is the file the mock wrote
+	configFile := a.ConfigFile()
+	c.Check(filepath.IsAbs(configFile), Equals, true)
+	c.Check(configFile, Equals, filepath.Join(rootdir, "boot/androidboot/androidboot.env"))
+	c.Check(configFile, testutil.FileEquals, "")
+	st, err := os.Stat(configFile)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *androidBootTestSuite) TestNewAndroidbootWithRoot(c *C) {
+	rootdir := c.MkDir()
+	c.Check(bootloader.NewAndroidBootWithRoot(rootdir), IsNil)
+
+	bootloader.MockAndroidBootFile(c, rootdir, 0644)
+	a := bootloader.NewAndroidBootWithRoot(rootdir)
+	c.Assert(a, NotNil)
+	c.Check(a.Dir(), Equals, filepath.Join(rootdir, "boot/androidboot"))
+	c.Check(a.ConfigFile(), Equals, filepath.Join(rootdir, "boot/androidboot/androidboot.env"))
+
+	err := a.SetBootVars(map[string]string{"snap_mode": "try"})
+	c.Assert(err, IsNil)
+	v, err := a.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(v["snap_mode"], Equals, "try")
+
+	// the global root is left alone
+	v, err = bootloader.NewAndroidBoot().GetBootVars("snap_mode")
+	c.Assert(err, Equals, androidbootenv.ErrEmptyEnvironment)
+	c.Check(v["snap_mode"], Equals, "")
+}
+
 func (s *androidBootTestSuite) TestSetGetBootVar(c *C) {
 	a := bootloader.NewAndroidBoot()
 	bootVars := map[string]string{"snap_mode": "try"}
@@ -81,6 +125,212 @@ func (s *androidBootTestSuite) TestSetGetBootVar(c *C) {
 	c.Check(v["snap_mode"], Equals, "try")
 }
 
+func (s *androidBootTestSuite) TestSetBootVarsWellKnown(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+
+	bootVars := map[string]string{
+		"snap_mode":       "try",
+		"snap_core":       "core_1.snap",
+		"snap_try_core":   "core_2.snap",
+		"snap_kernel":     "pc-kernel_1.snap",
+		"snap_try_kernel": "pc-kernel_x1.snap",
+	}
+	err := a.SetBootVars(bootVars)
+	c.Assert(err, IsNil)
+
+	v, err := a.GetBootVars("snap_mode", "snap_core", "snap_try_core", "snap_kernel", "snap_try_kernel")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, bootVars)
+}
+
+func (s *androidBootTestSuite) TestSetBootVarsInvalid(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+
+	for _, tc := range []struct {
+		key, value string
+		err        string
+	}{
+		{"", "try", `cannot set boot variable with empty name`},
+		{"#snap_mode", "try", `cannot set boot variable "#snap_mode": name cannot start with '#'`},
+		{"snap=mode", "try", `cannot set boot variable "snap=mode": name cannot contain '=', newlines or NUL bytes`},
+		{"snap\nmode", "try", `cannot set boot variable "snap\\nmode": name cannot contain '=', newlines or NUL bytes`},
+		{"snap\x00mode", "try", `cannot set boot variable "snap\\x00mode": name cannot contain '=', newlines or NUL bytes`},
+		{"snap_mode", "try\nsnap_kernel=foo", `cannot set boot variable "snap_mode" to "try\\nsnap_kernel=foo": value cannot contain '=', newlines or NUL bytes`},
+		{"snap_mode", "try\rfoo", `cannot set boot variable "snap_mode" to "try\\rfoo": value cannot contain '=', newlines or NUL bytes`},
+		{"snap_mode", "try\x00", `cannot set boot variable "snap_mode" to "try\\x00": value cannot contain '=', newlines or NUL bytes`},
+		{"snap_mode", "a=b", `cannot set boot variable "snap_mode" to "a=b": value cannot contain '=', newlines or NUL bytes`},
+	} {
+		err := a.SetBootVars(map[string]string{"snap_core": "core_1.snap", tc.key: tc.value})
+		c.Check(err, ErrorMatches, tc.err)
+	}
+
+	// nothing was written
+	content, err := ioutil.ReadFile(a.ConfigFile())
+	c.Assert(err, IsNil)
+	c.Check(content, HasLen, 0)
+}
+
+func (s *androidBootTestSuite) TestSetBootVarsDryRun(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+	dryRun, ok := a.(interface {
+		SetBootVarsDryRun(values map[string]string) ([]byte, error)
+	})
+	c.Assert(ok, Equals, true)
+
+	err := a.SetBootVars(map[string]string{"snap_mode": "", "snap_kernel": "k_1.snap"})
+	c.Assert(err, IsNil)
+	before, err := ioutil.ReadFile(a.ConfigFile())
+	c.Assert(err, IsNil)
+
+	out, err := dryRun.SetBootVarsDryRun(map[string]string{"snap_mode": "try", "snap_try_kernel": "k_2.snap"})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "snap_kernel=k_1.snap\nsnap_mode=try\nsnap_try_kernel=k_2.snap\n")
+
+	// the environment file is left alone
+	c.Check(a.ConfigFile(), testutil.FileEquals, before)
+
+	// invalid values are rejected as with SetBootVars
+	_, err = dryRun.SetBootVarsDryRun(map[string]string{"snap_mode": "a=b"})
+	c.Check(err, ErrorMatches, `cannot set boot variable "snap_mode" to "a=b": value cannot contain '=', newlines or NUL bytes`)
+}
+
+func (s *androidBootTestSuite) TestGetBootVarsAll(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+	all, ok := a.(interface {
+		GetBootVarsAll() (map[string]string, error)
+	})
+	c.Assert(ok, Equals, true)
+
+	v, err := all.GetBootVarsAll()
+	c.Assert(err, Equals, androidbootenv.ErrEmptyEnvironment)
+	c.Check(v, HasLen, 0)
+
+	err = ioutil.WriteFile(a.ConfigFile(), []byte("# comment\nsnap_mode=try\n\nsnap_kernel=k_1.snap\nsnap_mode=\n"), 0644)
+	c.Assert(err, IsNil)
+	v, err = all.GetBootVarsAll()
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{
+		"snap_mode":   "",
+		"snap_kernel": "k_1.snap",
+	})
+}
+
+func (s *androidBootTestSuite) TestGetBootVarsEmptyOrGarbledEnv(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+	all, ok := a.(interface {
+		GetBootVarsAll() (map[string]string, error)
+	})
+	c.Assert(ok, Equals, true)
+
+	// empty files, or files with only comments, have no values set,
+	// which is reported along with the unset values
+	for _, content := range []string{"", "\n  \n", "# nothing set yet\n"} {
+		err := ioutil.WriteFile(a.ConfigFile(), []byte(content), 0644)
+		c.Assert(err, IsNil)
+
+		v, err := a.GetBootVars("snap_mode")
+		c.Check(err, Equals, androidbootenv.ErrEmptyEnvironment)
+		c.Check(v, DeepEquals, map[string]string{"snap_mode": ""})
+		v, err = all.GetBootVarsAll()
+		c.Check(err, Equals, androidbootenv.ErrEmptyEnvironment)
+		c.Check(v, NotNil)
+		c.Check(v, HasLen, 0)
+	}
+
+	// but garbled ones are refused
+	err := ioutil.WriteFile(a.ConfigFile(), []byte("snap_mode=try\nsnap_ker\x00\x00"), 0644)
+	c.Assert(err, IsNil)
+	garbled := `cannot parse environment file ".*/androidboot.env": invalid line "snap_ker\\x00\\x00"`
+	_, err = a.GetBootVars("snap_mode")
+	c.Check(err, ErrorMatches, garbled)
+	_, err = all.GetBootVarsAll()
+	c.Check(err, ErrorMatches, garbled)
+
+	// the environment can be rewritten
+	err = a.SetBootVars(map[string]string{"snap_mode": ""})
+	c.Assert(err, IsNil)
+	v, err := a.GetBootVars("snap_mode", "snap_kernel")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{"snap_mode": "", "snap_kernel": ""})
+}
+
+func (s *androidBootTestSuite) TestGetBootVarsInstalledEmptyConfig(c *C) {
+	// gadgets may ship an empty config, which is installed as is
+	gadgetDir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(gadgetDir, "androidboot.conf"), nil, 0644)
+	c.Assert(err, IsNil)
+	err = bootloader.InstallBootConfig(gadgetDir)
+	c.Assert(err, IsNil)
+
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+	c.Check(a.ConfigFile(), testutil.FileEquals, "")
+	v, err := a.GetBootVars("snap_mode", "snap_kernel")
+	c.Check(err, Equals, androidbootenv.ErrEmptyEnvironment)
+	c.Check(v, DeepEquals, map[string]string{"snap_mode": "", "snap_kernel": ""})
+
+	// setting variables turns it into a regular environment
+	err = a.SetBootVars(map[string]string{"snap_kernel": "k1"})
+	c.Assert(err, IsNil)
+	v, err = a.GetBootVars("snap_mode", "snap_kernel")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{"snap_mode": "", "snap_kernel": "k1"})
+}
+
+func (s *androidBootTestSuite) TestSetGetBootVarConcurrent(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- a.SetBootVars(map[string]string{
+				fmt.Sprintf("key%d", i): fmt.Sprintf("value%d", i),
+			})
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			v, err := a.GetBootVars(fmt.Sprintf("key%d", i))
+			// reads can happen before anything got written
+			if err == androidbootenv.ErrEmptyEnvironment {
+				err = nil
+			}
+			if err == nil && v[fmt.Sprintf("key%d", i)] != "" && v[fmt.Sprintf("key%d", i)] != fmt.Sprintf("value%d", i) {
+				err = fmt.Errorf("unexpected value %q for key%d", v[fmt.Sprintf("key%d", i)], i)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Check(err, IsNil)
+	}
+
+	// no update got lost and the file is not corrupt
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("key%d", i)
+	}
+	v, err := a.GetBootVars(names...)
+	c.Assert(err, IsNil)
+	for i := 0; i < n; i++ {
+		c.Check(v[fmt.Sprintf("key%d", i)], Equals, fmt.Sprintf("value%d", i))
+	}
+	content, err := ioutil.ReadFile(a.ConfigFile())
+	c.Assert(err, IsNil)
+	c.Check(strings.Count(string(content), "\n"), Equals, n)
+}
+
 func (s *androidBootTestSuite) TestExtractKernelAssetsNoUnpacksKernel(c *C) {
 	a := bootloader.NewAndroidBoot()
 
@@ -109,3 +359,118 @@ func (s *androidBootTestSuite) TestExtractKernelAssetsNoUnpacksKernel(c *C) {
 	kernimg := filepath.Join(a.Dir(), "ubuntu-kernel_42.snap", "kernel.img")
 	c.Assert(osutil.FileExists(kernimg), Equals, false)
 }
+
+func (s *androidBootTestSuite) TestExtractKernelAssetsUnpacksKernel(c *C) {
+	a := bootloader.NewAndroidBootWithRoot(dirs.GlobalRootDir, bootloader.WithKernelUnpack())
+	c.Assert(a, NotNil)
+
+	files := [][]string{
+		{"kernel.img", "I'm a kernel"},
+		{"initrd.img", "...and I'm an initrd"},
+		{"meta/kernel.yaml", "version: 4.2"},
+	}
+	si := &snap.SideInfo{
+		RealName: "ubuntu-kernel",
+		Revision: snap.R(42),
+	}
+	fn := snaptest.MakeTestSnapWithFiles(c, packageKernel, files)
+	snapf, err := snap.Open(fn)
+	c.Assert(err, IsNil)
+
+	info, err := snap.ReadInfoFromSnapFile(snapf, si)
+	c.Assert(err, IsNil)
+
+	err = a.ExtractKernelAssets(info, snapf)
+	c.Assert(err, IsNil)
+
+	// kernel and initrd are extracted
+	kernimg := filepath.Join(a.Dir(), "ubuntu-kernel_42.snap", "kernel.img")
+	c.Assert(kernimg, testutil.FileEquals, "I'm a kernel")
+	initrdimg := filepath.Join(a.Dir(), "ubuntu-kernel_42.snap", "initrd.img")
+	c.Assert(initrdimg, testutil.FileEquals, "...and I'm an initrd")
+
+	// and removed again
+	err = a.RemoveKernelAssets(info)
+	c.Assert(err, IsNil)
+	c.Check(osutil.FileExists(filepath.Dir(kernimg)), Equals, false)
+}
+
+func (s *androidBootTestSuite) TestManagedAssets(c *C) {
+	a := bootloader.NewAndroidBootWithRoot(dirs.GlobalRootDir, bootloader.WithKernelUnpack())
+	c.Assert(a, NotNil)
+
+	// only the environment when no kernel was unpacked
+	c.Check(a.ManagedAssets(), DeepEquals, []string{a.ConfigFile()})
+
+	files := [][]string{
+		{"kernel.img", "I'm a kernel"},
+		{"initrd.img", "...and I'm an initrd"},
+		{"meta/kernel.yaml", "version: 4.2"},
+	}
+	si := &snap.SideInfo{
+		RealName: "ubuntu-kernel",
+		Revision: snap.R(42),
+	}
+	fn := snaptest.MakeTestSnapWithFiles(c, packageKernel, files)
+	snapf, err := snap.Open(fn)
+	c.Assert(err, IsNil)
+
+	info, err := snap.ReadInfoFromSnapFile(snapf, si)
+	c.Assert(err, IsNil)
+
+	err = a.ExtractKernelAssets(info, snapf)
+	c.Assert(err, IsNil)
+
+	c.Check(a.ManagedAssets(), DeepEquals, []string{
+		a.ConfigFile(),
+		filepath.Join(a.Dir(), "ubuntu-kernel_42.snap"),
+	})
+
+	err = a.RemoveKernelAssets(info)
+	c.Assert(err, IsNil)
+	c.Check(a.ManagedAssets(), DeepEquals, []string{a.ConfigFile()})
+}
+
+func (s *androidBootTestSuite) TestRemoveKernelAssets(c *C) {
+	a := bootloader.NewAndroidBoot()
+	c.Assert(a, NotNil)
+
+	files := [][]string{
+		{"kernel.img", "I'm a kernel"},
+		{"initrd.img", "...and I'm an initrd"},
+		{"meta/kernel.yaml", "version: 4.2"},
+	}
+	si := &snap.SideInfo{
+		RealName: "ubuntu-kernel",
+		Revision: snap.R(42),
+	}
+	fn := snaptest.MakeTestSnapWithFiles(c, packageKernel, files)
+	snapf, err := snap.Open(fn)
+	c.Assert(err, IsNil)
+
+	info, err := snap.ReadInfoFromSnapFile(snapf, si)
+	c.Assert(err, IsNil)
+
+	err = a.ExtractKernelAssets(info, snapf)
+	c.Assert(err, IsNil)
+
+	// nothing was extracted, removal is a no-op
+	err = a.RemoveKernelAssets(info)
+	c.Assert(err, IsNil)
+
+	// leftover meta files are removed
+	assetsDir := filepath.Join(a.Dir(), "ubuntu-kernel_42.snap")
+	err = os.MkdirAll(filepath.Join(assetsDir, "meta"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(assetsDir, "meta", "kernel.yaml"), []byte("version: 4.2"), 0644)
+	c.Assert(err, IsNil)
+
+	err = a.RemoveKernelAssets(info)
+	c.Assert(err, IsNil)
+	exists, _, err := osutil.DirExists(assetsDir)
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+
+	// the environment is left alone
+	c.Check(osutil.FileExists(a.ConfigFile()), Equals, true)
+}
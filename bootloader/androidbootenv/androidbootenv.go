@@ -22,14 +22,21 @@ package androidbootenv
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 )
 
+// ErrEmptyEnvironment is returned by LoadStrict when the environment file
+// does not set any variable. The environment is still loaded, with all
+// variables unset.
+var ErrEmptyEnvironment = errors.New("environment file has no variables set")
+
 type Env struct {
 	// Map with key-value strings
 	env map[string]string
@@ -52,7 +59,37 @@ func (a *Env) Set(key, value string) {
 	a.env[key] = value
 }
 
+// All returns a copy of all the key-value pairs of the environment.
+func (a *Env) All() map[string]string {
+	out := make(map[string]string, len(a.env))
+	for k, v := range a.env {
+		out[k] = v
+	}
+	return out
+}
+
+// Load reads the environment file, lines that cannot be parsed are
+// skipped with a warning.
 func (a *Env) Load() error {
+	return a.load(false)
+}
+
+// LoadStrict reads the environment file like Load but fails if the
+// file has lines that cannot be parsed, which happens when the file got
+// corrupted. An empty file, which is what gets installed from a gadget
+// that does not set any variable, is loaded but ErrEmptyEnvironment is
+// returned so that callers can tell it apart from unset variables.
+func (a *Env) LoadStrict() error {
+	if err := a.load(true); err != nil {
+		return err
+	}
+	if len(a.env) == 0 {
+		return ErrEmptyEnvironment
+	}
+	return nil
+}
+
+func (a *Env) load(strict bool) error {
 	file, err := os.Open(a.path)
 	if err != nil {
 		return err
@@ -61,13 +98,22 @@ func (a *Env) Load() error {
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		l := strings.SplitN(scanner.Text(), "=", 2)
+		line := scanner.Text()
+		// skip blank lines and comments
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l := strings.SplitN(line, "=", 2)
 		// be liberal in what you accept
 		if len(l) < 2 {
+			if strict {
+				return fmt.Errorf("cannot parse environment file %q: invalid line %q", a.path, line)
+			}
 			logger.Noticef("WARNING: bad value while parsing %v (line: %q)",
-				a.path, scanner.Text())
+				a.path, line)
 			continue
 		}
+		// the last value of a key wins
 		a.env[l[0]] = l[1]
 	}
 	if err := scanner.Err(); err != nil {
@@ -77,14 +123,22 @@ func (a *Env) Load() error {
 	return nil
 }
 
-func (a *Env) Save() error {
-	var w bytes.Buffer
+// Bytes returns the environment serialized the way Save writes it,
+// with the keys sorted.
+func (a *Env) Bytes() []byte {
+	keys := make([]string, 0, len(a.env))
+	for k := range a.env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	for k, v := range a.env {
-		if _, err := fmt.Fprintf(&w, "%s=%s\n", k, v); err != nil {
-			return err
-		}
+	var w bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&w, "%s=%s\n", k, a.env[k])
 	}
+	return w.Bytes()
+}
 
-	return osutil.AtomicWriteFile(a.path, w.Bytes(), 0644, 0)
+func (a *Env) Save() error {
+	return osutil.AtomicWriteFile(a.path, a.Bytes(), 0644, 0)
 }
@@ -20,12 +20,14 @@
 package androidbootenv_test
 
 import (
+	"io/ioutil"
 	"path/filepath"
 	"testing"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/bootloader/androidbootenv"
+	"github.com/snapcore/snapd/testutil"
 )
 
 // Hook up check.v1 into the "go test" runner
@@ -67,3 +69,68 @@ func (a *androidbootenvTestSuite) TestSaveAndLoad(c *C) {
 	c.Assert(env2.Get("key2"), Equals, "")
 	c.Assert(env2.Get("key3"), Equals, "value3")
 }
+
+func (a *androidbootenvTestSuite) TestLoadSkipsCommentsAndDuplicates(c *C) {
+	content := `# a comment
+key1=value1
+
+key2=value2
+#key3=commented
+key1=value1-again
+bad-line
+`
+	err := ioutil.WriteFile(a.envPath, []byte(content), 0644)
+	c.Assert(err, IsNil)
+
+	err = a.env.Load()
+	c.Assert(err, IsNil)
+	c.Check(a.env.All(), DeepEquals, map[string]string{
+		"key1": "value1-again",
+		"key2": "value2",
+	})
+}
+
+func (a *androidbootenvTestSuite) TestLoadStrict(c *C) {
+	err := ioutil.WriteFile(a.envPath, []byte("# a comment\nkey1=value1\n"), 0644)
+	c.Assert(err, IsNil)
+	err = a.env.LoadStrict()
+	c.Assert(err, IsNil)
+	c.Check(a.env.All(), DeepEquals, map[string]string{"key1": "value1"})
+
+	// an empty file has no values, which is reported
+	err = ioutil.WriteFile(a.envPath, nil, 0644)
+	c.Assert(err, IsNil)
+	env := androidbootenv.NewEnv(a.envPath)
+	err = env.LoadStrict()
+	c.Check(err, Equals, androidbootenv.ErrEmptyEnvironment)
+	c.Check(env.All(), HasLen, 0)
+	// Load does not care
+	err = androidbootenv.NewEnv(a.envPath).Load()
+	c.Check(err, IsNil)
+
+	err = ioutil.WriteFile(a.envPath, []byte("key1=value1\nbad-line\n"), 0644)
+	c.Assert(err, IsNil)
+	err = androidbootenv.NewEnv(a.envPath).LoadStrict()
+	c.Check(err, ErrorMatches, `cannot parse environment file ".*": invalid line "bad-line"`)
+
+	// Load is still liberal
+	err = androidbootenv.NewEnv(a.envPath).Load()
+	c.Check(err, IsNil)
+}
+
+func (a *androidbootenvTestSuite) TestAllReturnsCopy(c *C) {
+	a.env.Set("key", "value")
+	all := a.env.All()
+	all["key"] = "other"
+	c.Check(a.env.Get("key"), Equals, "value")
+}
+
+func (a *androidbootenvTestSuite) TestBytes(c *C) {
+	a.env.Set("key2", "value2")
+	a.env.Set("key1", "")
+	c.Check(string(a.env.Bytes()), Equals, "key1=\nkey2=value2\n")
+
+	err := a.env.Save()
+	c.Assert(err, IsNil)
+	c.Check(a.envPath, testutil.FileEquals, "key1=\nkey2=value2\n")
+}
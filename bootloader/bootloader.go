@@ -25,6 +25,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
 )
@@ -39,6 +40,11 @@ const (
 	// Initial and final values
 	modeTry     = "try"
 	modeSuccess = ""
+
+	// bootloader variables holding the kernel that is known to
+	// boot and the kernel to try on the next boot
+	kernelVar    = "snap_kernel"
+	tryKernelVar = "snap_try_kernel"
 )
 
 var (
@@ -69,12 +75,16 @@ type Bootloader interface {
 
 	// RemoveKernelAssets removes the assets for the given kernel snap.
 	RemoveKernelAssets(s snap.PlaceInfo) error
+
+	// ManagedAssets returns the paths of the files and directories
+	// that are written by snapd on behalf of the bootloader.
+	ManagedAssets() []string
 }
 
 // InstallBootConfig installs the bootloader config from the gadget
 // snap dir into the right place.
 func InstallBootConfig(gadgetDir string) error {
-	for _, bl := range []Bootloader{&grub{}, &uboot{}, &androidboot{}} {
+	for _, bl := range []Bootloader{&grub{}, &uboot{}, &androidboot{rootdir: dirs.GlobalRootDir}} {
 		// the bootloader config file has to be root of the gadget snap
 		gadgetFile := filepath.Join(gadgetDir, bl.Name()+".conf")
 		if !osutil.FileExists(gadgetFile) {
@@ -172,6 +182,42 @@ func MarkBootSuccessful(bootloader Bootloader) error {
 	return bootloader.SetBootVars(m)
 }
 
+// SetTryBranch sets up the bootloader to try booting the given kernel
+// snap on the next boot.
+func SetTryBranch(bl Bootloader, kernel snap.PlaceInfo) error {
+	return bl.SetBootVars(map[string]string{
+		bootmodeVar:  modeTry,
+		tryKernelVar: filepath.Base(kernel.MountFile()),
+	})
+}
+
+// CommitTryBranch makes the kernel that is being tried the one that is
+// known to boot. Nothing is done if no kernel is being tried.
+func CommitTryBranch(bl Bootloader) error {
+	m, err := bl.GetBootVars(tryKernelVar)
+	if err != nil {
+		return err
+	}
+	if m[tryKernelVar] == "" {
+		return nil
+	}
+
+	return bl.SetBootVars(map[string]string{
+		bootmodeVar:  modeSuccess,
+		kernelVar:    m[tryKernelVar],
+		tryKernelVar: "",
+	})
+}
+
+// ClearTryBranch drops the kernel that is being tried, the next boot
+// uses the kernel that is known to boot.
+func ClearTryBranch(bl Bootloader) error {
+	return bl.SetBootVars(map[string]string{
+		bootmodeVar:  modeSuccess,
+		tryKernelVar: "",
+	})
+}
+
 func extractKernelAssetsToBootDir(bootDir string, s *snap.Info, snapf snap.Container) error {
 	// now do the kernel specific bits
 	blobName := filepath.Base(s.MountFile())
@@ -200,6 +246,22 @@ func extractKernelAssetsToBootDir(bootDir string, s *snap.Info, snapf snap.Conta
 	return dir.Sync()
 }
 
+// kernelAssetsDirsInBootDir returns the per-kernel directories that
+// extractKernelAssetsToBootDir created in bootDir.
+func kernelAssetsDirsInBootDir(bootDir string) []string {
+	matches, err := filepath.Glob(filepath.Join(bootDir, "*.snap"))
+	if err != nil {
+		return nil
+	}
+	var assetDirs []string
+	for _, m := range matches {
+		if osutil.IsDirectory(m) {
+			assetDirs = append(assetDirs, m)
+		}
+	}
+	return assetDirs
+}
+
 func removeKernelAssetsFromBootDir(bootDir string, s snap.PlaceInfo) error {
 	// remove the kernel blob
 	blobName := filepath.Base(s.MountFile())
@@ -122,6 +122,68 @@ func (s *PartitionTestSuite) TestMarkBootSuccessfulKKernelUpdate(c *C) {
 	})
 }
 
+func (s *PartitionTestSuite) TestTryBranch(c *C) {
+	b := boottest.NewMockBootloader("mocky", c.MkDir())
+	b.BootVars["snap_kernel"] = "k_1.snap"
+	kernel := &snap.Info{SideInfo: snap.SideInfo{RealName: "k", Revision: snap.R(2)}}
+
+	err := SetTryBranch(b, kernel)
+	c.Assert(err, IsNil)
+	c.Check(b.BootVars, DeepEquals, map[string]string{
+		"snap_mode":       "try",
+		"snap_kernel":     "k_1.snap",
+		"snap_try_kernel": "k_2.snap",
+	})
+
+	err = ClearTryBranch(b)
+	c.Assert(err, IsNil)
+	c.Check(b.BootVars, DeepEquals, map[string]string{
+		"snap_mode":       "",
+		"snap_kernel":     "k_1.snap",
+		"snap_try_kernel": "",
+	})
+
+	// nothing to commit
+	err = CommitTryBranch(b)
+	c.Assert(err, IsNil)
+	c.Check(b.BootVars["snap_kernel"], Equals, "k_1.snap")
+
+	err = SetTryBranch(b, kernel)
+	c.Assert(err, IsNil)
+	err = CommitTryBranch(b)
+	c.Assert(err, IsNil)
+	c.Check(b.BootVars, DeepEquals, map[string]string{
+		"snap_mode":       "",
+		"snap_kernel":     "k_2.snap",
+		"snap_try_kernel": "",
+	})
+}
+
+func (s *PartitionTestSuite) TestTryBranchAndroidboot(c *C) {
+	MockAndroidBootFile(c, dirs.GlobalRootDir, 0644)
+	a := newAndroidBoot()
+	c.Assert(a, NotNil)
+
+	err := SetTryBranch(a, &snap.Info{SideInfo: snap.SideInfo{RealName: "k", Revision: snap.R(2)}})
+	c.Assert(err, IsNil)
+	m, err := a.GetBootVars("snap_mode", "snap_try_kernel")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snap_mode":       "try",
+		"snap_try_kernel": "k_2.snap",
+	})
+
+	err = CommitTryBranch(a)
+	c.Assert(err, IsNil)
+	m, err = a.GetBootVars("snap_mode", "snap_kernel", "snap_try_kernel")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snap_mode":       "",
+		"snap_kernel":     "k_2.snap",
+		"snap_try_kernel": "",
+	})
+}
+
 func (s *PartitionTestSuite) TestInstallBootloaderConfigNoConfig(c *C) {
 	err := InstallBootConfig(c.MkDir())
 	c.Assert(err, ErrorMatches, `cannot find boot config in.*`)
@@ -31,8 +31,8 @@ func NewAndroidBoot() Bootloader {
 	return newAndroidBoot()
 }
 
-func MockAndroidBootFile(c *C, mode os.FileMode) {
-	f := &androidboot{}
+func MockAndroidBootFile(c *C, rootdir string, mode os.FileMode) {
+	f := &androidboot{rootdir: rootdir}
 	err := os.MkdirAll(f.Dir(), 0755)
 	c.Assert(err, IsNil)
 	err = ioutil.WriteFile(f.ConfigFile(), nil, mode)
@@ -94,3 +94,7 @@ func (g *grub) ExtractKernelAssets(s *snap.Info, snapf snap.Container) error {
 func (g *grub) RemoveKernelAssets(s snap.PlaceInfo) error {
 	return removeKernelAssetsFromBootDir(g.Dir(), s)
 }
+
+func (g *grub) ManagedAssets() []string {
+	return append([]string{g.envFile()}, kernelAssetsDirsInBootDir(g.Dir())...)
+}
@@ -101,3 +101,7 @@ func (u *uboot) ExtractKernelAssets(s *snap.Info, snapf snap.Container) error {
 func (u *uboot) RemoveKernelAssets(s snap.PlaceInfo) error {
 	return removeKernelAssetsFromBootDir(u.Dir(), s)
 }
+
+func (u *uboot) ManagedAssets() []string {
+	return append([]string{u.envFile()}, kernelAssetsDirsInBootDir(u.Dir())...)
+}
@@ -60,6 +60,7 @@ version: 1.0
 apps:
  app:
   command: run-app cmd-arg1 $SNAP_DATA
+  daemon: simple
   stop-command: stop-app
   post-stop-command: post-stop-app
   completer: you/complete/me
@@ -69,6 +70,7 @@ apps:
    MY_PATH: $PATH
  app2:
   command: run-app2
+  daemon: simple
   stop-command: stop-app2
   post-stop-command: post-stop-app2
   command-chain: [chain1, chain2]
@@ -20,8 +20,11 @@
 package devicestate_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -222,7 +225,7 @@ func (s *deviceMgrSuite) signSerial(c *C, bhv *devicestatetest.DeviceServiceBeha
 	keyID := ""
 
 	switch model {
-	case "pc", "pc2":
+	case "pc", "pc2", "bad-model-foo":
 	case "classic-alt-store":
 		c.Check(brandID, Equals, "canonical")
 	case "generic-classic":
@@ -898,6 +901,71 @@ func (s *deviceMgrSuite) TestDoRequestSerialMaxTentatives(c *C) {
 	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot retrieve request-id for making a request for a serial: unexpected status 501.*`)
 }
 
+func (s *deviceMgrSuite) TestDoRequestSerialMalformedRequestID(c *C) {
+	privKey, _ := assertstest.GenerateKey(testKeyLength)
+
+	// immediate
+	r := devicestate.MockRetryInterval(0)
+	defer r()
+
+	r = devicestate.MockMaxTentatives(2)
+	defer r()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		RequestIDResponse: func(c *C, w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(200)
+			io.WriteString(w, `{"request-id": "REQID-1", "extra": `)
+		},
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
+
+	restore := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer restore()
+
+	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+		KeyID: privKey.PublicKey().ID(),
+	})
+	devicestate.KeypairManager(s.mgr).Put(privKey)
+
+	t := s.state.NewTask("request-serial", "test")
+	chg := s.state.NewChange("become-operational", "...")
+	chg.AddTask(t)
+
+	// avoid full seeding
+	s.seeding()
+
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.DoingStatus)
+
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot read response with request-id for making a request for a serial: unexpected EOF.*`)
+}
+
 func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
@@ -970,6 +1038,65 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
 	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
+func (s *deviceMgrSuite) TestFullDeviceRegistrationPollCount(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		PollCount: 1,
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// immediately
+	r3 := devicestate.MockRetryInterval(0)
+	defer r3()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	// runs the whole device registration process with polling
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	c.Check(becomeOperational.Status().Ready(), Equals, false)
+
+	// needs 1 more Retry pass of polling
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(bhv.SerialAttempts, Equals, 2)
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "10000")
+}
+
 func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
@@ -981,6 +1108,17 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C)
 	bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
 		c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
 	}
+	checked := false
+	bhv.CheckSerialRequest = func(c *C, serialReq *asserts.SerialRequest, r *http.Request) {
+		checked = true
+		c.Check(serialReq.BrandID(), Equals, "canonical")
+		c.Check(serialReq.Model(), Equals, "pc2")
+		c.Check(serialReq.Serial(), Equals, "Y9999")
+		c.Check(serialReq.RequestID(), Equals, "REQID-1")
+		c.Check(string(serialReq.Body()), Equals, "mac: 00:00:00:00:ff:00\n")
+		c.Check(serialReq.DeviceKey(), NotNil)
+		c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
+	}
 
 	mockServer := s.mockServer(c, "REQID-1", bhv)
 	defer mockServer.Close()
@@ -1050,6 +1188,7 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C)
 	c.Check(device.Brand, Equals, "canonical")
 	c.Check(device.Model, Equals, "pc2")
 	c.Check(device.Serial, Equals, "Y9999")
+	c.Check(checked, Equals, true)
 
 	a, err := s.db.Find(asserts.SerialType, map[string]string{
 		"brand-id": "canonical",
@@ -1271,6 +1410,371 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationErrorBackoff(c *C) {
 	c.Check(device.Serial, Equals, "10000")
 }
 
+func (s *deviceMgrSuite) TestFullDeviceRegistrationSerialServerErrors(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		SerialResponseCodes: []int{502, 503},
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// immediately
+	r3 := devicestate.MockRetryInterval(0)
+	defer r3()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	// runs the whole device registration process
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+
+	// needs 2 more Retry passes for the temporary errors
+	for i := 0; i < 2; i++ {
+		s.state.Unlock()
+		s.settle(c)
+		s.state.Lock()
+	}
+
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(bhv.SerialResponseCodes, HasLen, 0)
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "9999")
+}
+
+func (s *deviceMgrSuite) TestFullDeviceRegistrationSerialServerError500(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		SerialResponseCodes: []int{500},
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+
+	// 500 is not considered temporary
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot deliver device serial request: unexpected status 500.*`)
+}
+
+func (s *deviceMgrSuite) TestFullDeviceRegistrationServerStalls(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		ResponseDelay: 10 * time.Second,
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	// closing waits for the stalled requests to be done
+	start := time.Now()
+	defer func() {
+		mockServer.Close()
+		c.Check(time.Since(start) < bhv.ResponseDelay, Equals, true)
+	}()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	r3 := devicestate.MockRequestTimeout(50 * time.Millisecond)
+	defer r3()
+
+	// immediately
+	r4 := devicestate.MockRetryInterval(0)
+	defer r4()
+
+	r5 := devicestate.MockMaxTentatives(2)
+	defer r5()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+
+	// needs one more Retry pass before giving up
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot retrieve request-id for making a request for a serial: .*Client.Timeout exceeded.*`)
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "")
+}
+
+func (s *deviceMgrSuite) TestFullDeviceRegistrationUntrustedTLS(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		ReqID:      "REQID-1",
+		SignSerial: s.signSerial,
+	}
+	mockServer := devicestatetest.MockDeviceServiceTLS(c, bhv)
+	defer mockServer.Close()
+
+	// sanity check that the server can be talked to when trusting
+	// its certificate
+	certs := x509.NewCertPool()
+	certs.AddCert(mockServer.Certificate())
+	client := httputil.NewHTTPClient(&httputil.ClientOptions{
+		TLSConfig: &tls.Config{RootCAs: certs},
+	})
+	resp, err := client.Head(mockServer.URL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 200)
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+
+	// the self-signed certificate is not trusted by snapd
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot retrieve request-id for making a request for a serial: .*x509: certificate signed by unknown authority.*`)
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "")
+}
+
+func (s *deviceMgrSuite) TestFullDeviceRegistrationSignSerialN(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	var reqIDs []string
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		ReqID: devicestatetest.ReqIDBadRequest,
+		SignSerialN: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, n int, serialReq *asserts.SerialRequest, headers map[string]interface{}, body []byte) (asserts.Assertion, error) {
+			reqIDs = append(reqIDs, serialReq.RequestID())
+			headers["serial"] = fmt.Sprintf("serial-%d", n)
+			return s.signSerial(c, bhv, headers, body)
+		},
+	}
+	mockServer := devicestatetest.MockDeviceService(c, bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	// the first attempt is rejected
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	firstTryID := becomeOperational.ID()
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot deliver device serial request: bad serial-request.*`)
+	c.Check(reqIDs, HasLen, 0)
+
+	// the second attempt gets the serial for the second request
+	bhv.ReqID = "REQID-2"
+	devicestate.SetLastBecomeOperationalAttempt(s.mgr, time.Now().Add(-15*time.Minute))
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational = s.findBecomeOperationalChange(firstTryID)
+	c.Assert(becomeOperational, NotNil)
+	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(reqIDs, DeepEquals, []string{"REQID-2"})
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "serial-1")
+}
+
+func (s *deviceMgrSuite) TestFullDeviceRegistrationRateLimited(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		RateLimitUntilAttempt: 2,
+		RetryAfter:            10 * time.Minute,
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	// the first attempt is rate limited
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	firstTryID := becomeOperational.ID()
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot deliver device serial request: unexpected status 429.*`)
+	c.Check(bhv.SerialAttempts, Equals, 1)
+
+	// no new attempt while backing off
+	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, time.Now()), Equals, true)
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+	c.Check(bhv.SerialAttempts, Equals, 1)
+
+	// the attempt after the backoff succeeds
+	devicestate.SetLastBecomeOperationalAttempt(s.mgr, time.Now().Add(-15*time.Minute))
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational = s.findBecomeOperationalChange(firstTryID)
+	c.Assert(becomeOperational, NotNil)
+	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(bhv.SerialAttempts, Equals, 2)
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "9999")
+}
+
 func (s *deviceMgrSuite) TestEnsureBecomeOperationalShouldBackoff(c *C) {
 	t0 := time.Now()
 	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t0), Equals, false)
@@ -1336,6 +1840,54 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationMismatchedSerial(c *C) {
 	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*obtained serial assertion does not match provided device identity information.*`)
 }
 
+func (s *deviceMgrSuite) TestFullDeviceRegistrationMismatchedSerialBrand(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		OverrideSerialHeaders: map[string]interface{}{
+			"authority-id": "other-brand",
+			"brand-id":     "other-brand",
+		},
+	}
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// setup state as will be done by first-boot
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "gadget",
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+
+	// mark as seeded
+	s.state.Set("seeded", true)
+
+	// try the whole device registration process
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*obtained serial assertion does not match provided device identity information \(brand, model, key id\): other-brand / pc / .*`)
+}
+
 func (s *deviceMgrSuite) TestModelAndSerial(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -20,12 +20,12 @@
 package devicestatetest
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"sync"
 	"time"
 
@@ -44,7 +44,49 @@ type DeviceServiceBehavior struct {
 	Head          func(c *C, bhv *DeviceServiceBehavior, w http.ResponseWriter, r *http.Request)
 	PostPreflight func(c *C, bhv *DeviceServiceBehavior, w http.ResponseWriter, r *http.Request)
 
+	// RequestIDResponse if set writes the whole response of the
+	// request-id endpoint instead of the default valid one
+	RequestIDResponse func(c *C, w http.ResponseWriter)
+
 	SignSerial func(c *C, bhv *DeviceServiceBehavior, headers map[string]interface{}, body []byte) (asserts.Assertion, error)
+	// SignSerialN is like SignSerial but also gets the index of the
+	// request to the serial endpoint, starting at 0, and the decoded
+	// serial-request, it takes precedence over SignSerial
+	SignSerialN func(c *C, bhv *DeviceServiceBehavior, n int, serialReq *asserts.SerialRequest, headers map[string]interface{}, body []byte) (asserts.Assertion, error)
+
+	// CheckSerialRequest is invoked with the decoded serial-request
+	// and the original HTTP request, after its signature has been
+	// checked, to let tests assert on what was sent
+	CheckSerialRequest func(c *C, serialReq *asserts.SerialRequest, r *http.Request)
+
+	// OverrideSerialHeaders are set on top of the headers passed to
+	// SignSerial(N), e.g. to obtain a serial for a different
+	// brand-id or model than the requested one
+	OverrideSerialHeaders map[string]interface{}
+
+	// SerialResponseCodes are the status codes to reply with, in
+	// order, to the first requests to the serial endpoint before
+	// handling them normally
+	SerialResponseCodes []int
+
+	// ResponseDelay is how long to stall before replying to POST
+	// requests, unless the request is cancelled first
+	ResponseDelay time.Duration
+
+	// RateLimitUntilAttempt makes the serial endpoint reply with 429
+	// and a Retry-After header set from RetryAfter to the attempts
+	// before the given one, counting from 1
+	RateLimitUntilAttempt int
+	RetryAfter            time.Duration
+
+	// PollCount is the number of requests to the serial endpoint
+	// to reply to with 202 before signing a serial, ReqIDPoll
+	// defaults it to defaultPollCount
+	PollCount int
+
+	// SerialAttempts is set to the number of requests seen by the
+	// serial endpoint
+	SerialAttempts int
 }
 
 // Request IDs for hard-coded behaviors.
@@ -55,12 +97,27 @@ const (
 	ReqIDSerialWithBadModel = "REQID-SERIAL-W-BAD-MODEL"
 )
 
+// defaultPollCount is the number of polls used for ReqIDPoll if
+// PollCount is not set.
+const defaultPollCount = 3
+
 const (
 	requestIDURLPath = "/api/v1/snaps/auth/request-id"
 	serialURLPath    = "/api/v1/snaps/auth/devices"
 )
 
 func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
+	return httptest.NewServer(deviceServiceHandler(c, bhv))
+}
+
+// MockDeviceServiceTLS is like MockDeviceService but serves over HTTPS
+// using a self-signed certificate, available via the Certificate method
+// of the returned server.
+func MockDeviceServiceTLS(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
+	return httptest.NewTLSServer(deviceServiceHandler(c, bhv))
+}
+
+func deviceServiceHandler(c *C, bhv *DeviceServiceBehavior) http.Handler {
 	expectedUserAgent := httputil.UserAgent()
 
 	// default URL paths
@@ -69,9 +126,14 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 		bhv.SerialURLPath = serialURLPath
 	}
 
+	pollCount := bhv.PollCount
+	if pollCount == 0 && bhv.ReqID == ReqIDPoll {
+		pollCount = defaultPollCount
+	}
+
 	var mu sync.Mutex
 	count := 0
-	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		default:
 			c.Fatalf("unexpected verb %q", r.Method)
@@ -92,6 +154,15 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 			bhv.PostPreflight(c, bhv, w, r)
 		}
 
+		if bhv.ResponseDelay != 0 {
+			select {
+			case <-time.After(bhv.ResponseDelay):
+			case <-r.Context().Done():
+				// the client gave up
+				return
+			}
+		}
+
 		switch r.URL.Path {
 		default:
 			c.Fatalf("unexpected POST request %q", r.URL.String())
@@ -100,13 +171,32 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 				w.WriteHeader(501)
 				return
 			}
-			w.WriteHeader(200)
 			c.Check(r.Header.Get("User-Agent"), Equals, expectedUserAgent)
+			if bhv.RequestIDResponse != nil {
+				bhv.RequestIDResponse(c, w)
+				return
+			}
+			w.WriteHeader(200)
 			io.WriteString(w, fmt.Sprintf(`{"request-id": "%s"}`, bhv.ReqID))
 		case bhv.SerialURLPath:
 			c.Check(r.Header.Get("User-Agent"), Equals, expectedUserAgent)
 
 			mu.Lock()
+			bhv.SerialAttempts++
+			if bhv.SerialAttempts < bhv.RateLimitUntilAttempt {
+				mu.Unlock()
+				w.Header().Set("Retry-After", strconv.Itoa(int(bhv.RetryAfter/time.Second)))
+				w.WriteHeader(429)
+				return
+			}
+			if len(bhv.SerialResponseCodes) > 0 {
+				code := bhv.SerialResponseCodes[0]
+				bhv.SerialResponseCodes = bhv.SerialResponseCodes[1:]
+				mu.Unlock()
+				w.WriteHeader(code)
+				return
+			}
+			n := count
 			serialNum := 9999 + count
 			count++
 			mu.Unlock()
@@ -119,6 +209,9 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 			c.Assert(ok, Equals, true)
 			err = asserts.SignatureCheck(serialReq, serialReq.DeviceKey())
 			c.Assert(err, IsNil)
+			if bhv.CheckSerialRequest != nil {
+				bhv.CheckSerialRequest(c, serialReq, r)
+			}
 			brandID := serialReq.BrandID()
 			model := serialReq.Model()
 			reqID := serialReq.RequestID()
@@ -130,7 +223,7 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 }`))
 				return
 			}
-			if reqID == ReqIDPoll && serialNum != 10002 {
+			if n < pollCount {
 				w.WriteHeader(202)
 				return
 			}
@@ -139,7 +232,7 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 				// use proposed serial
 				serialStr = serialReq.Serial()
 			}
-			serial, err := bhv.SignSerial(c, bhv, map[string]interface{}{
+			headers := map[string]interface{}{
 				"authority-id":        "canonical",
 				"brand-id":            brandID,
 				"model":               model,
@@ -147,15 +240,24 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 				"device-key":          serialReq.HeaderString("device-key"),
 				"device-key-sha3-384": serialReq.SignKeyID(),
 				"timestamp":           time.Now().Format(time.RFC3339),
-			}, serialReq.Body())
+			}
+			override := bhv.OverrideSerialHeaders
+			if reqID == ReqIDSerialWithBadModel && override == nil {
+				override = map[string]interface{}{"model": "bad-model-foo"}
+			}
+			for k, v := range override {
+				headers[k] = v
+			}
+			var serial asserts.Assertion
+			if bhv.SignSerialN != nil {
+				serial, err = bhv.SignSerialN(c, bhv, n, serialReq, headers, serialReq.Body())
+			} else {
+				serial, err = bhv.SignSerial(c, bhv, headers, serialReq.Body())
+			}
 			c.Assert(err, IsNil)
 			w.Header().Set("Content-Type", asserts.MediaType)
 			w.WriteHeader(200)
-			encoded := asserts.Encode(serial)
-			if reqID == ReqIDSerialWithBadModel {
-				encoded = bytes.Replace(encoded, []byte("model: pc"), []byte("model: bad-model-foo"), 1)
-			}
-			w.Write(encoded)
+			w.Write(asserts.Encode(serial))
 		}
-	}))
+	})
 }
@@ -58,6 +58,14 @@ func MockRetryInterval(interval time.Duration) (restore func()) {
 	}
 }
 
+func MockRequestTimeout(timeout time.Duration) (restore func()) {
+	old := requestTimeout
+	requestTimeout = timeout
+	return func() {
+		requestTimeout = old
+	}
+}
+
 func MockMaxTentatives(max int) (restore func()) {
 	old := maxTentatives
 	maxTentatives = max
@@ -140,10 +140,11 @@ func mustParse(s string) *url.URL {
 }
 
 var (
-	keyLength     = 4096
-	retryInterval = 60 * time.Second
-	maxTentatives = 15
-	baseStoreURL  = baseURL().ResolveReference(authRef)
+	keyLength      = 4096
+	retryInterval  = 60 * time.Second
+	maxTentatives  = 15
+	requestTimeout = 30 * time.Second
+	baseStoreURL   = baseURL().ResolveReference(authRef)
 
 	authRef    = mustParse("api/v1/snaps/auth/") // authRef must end in / for the following refs to work
 	reqIdRef   = mustParse("request-id")
@@ -519,7 +520,7 @@ func getSerial(t *state.Task, regCtx registrationContext, privKey asserts.Privat
 	st := t.State()
 	proxyConf := proxyconf.New(st)
 	client := httputil.NewHTTPClient(&httputil.ClientOptions{
-		Timeout:    30 * time.Second,
+		Timeout:    requestTimeout,
 		MayLogBody: true,
 		Proxy:      proxyConf.Conf,
 	})
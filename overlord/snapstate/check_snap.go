@@ -34,24 +34,13 @@ import (
 	"github.com/snapcore/snapd/snap"
 )
 
-// featureSet contains the flag values that can be listed in assumes entries
-// that this ubuntu-core actually provides.
-var featureSet = map[string]bool{
-	// Support for common data directory across revisions of a snap.
-	"common-data-dir": true,
-	// Support for the "Environment:" feature in snap.yaml
-	"snap-env": true,
-	// Support for the "command-chain" feature for apps and hooks in snap.yaml
-	"command-chain": true,
-}
-
 func checkAssumes(si *snap.Info) error {
 	missing := ([]string)(nil)
 	for _, flag := range si.Assumes {
 		if strings.HasPrefix(flag, "snapd") && checkVersion(flag[5:]) {
 			continue
 		}
-		if !featureSet[flag] {
+		if !snap.SupportedAssumesFeatures[flag] {
 			missing = append(missing, flag)
 		}
 	}
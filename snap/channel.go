@@ -21,6 +21,7 @@ package snap
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/snapcore/snapd/arch"
@@ -38,6 +39,34 @@ type Channel struct {
 	Branch       string `json:"branch,omitempty"`
 }
 
+// maxTrackLength is the maximum length of a track name.
+const maxTrackLength = 28
+
+// validTrack matches track names: ASCII alphanumerics optionally separated
+// by single dots, dashes or underscores.
+var validTrack = regexp.MustCompile(`^[a-zA-Z0-9](?:[_.-]?[a-zA-Z0-9])*$`)
+
+// ValidateDefaultTrack checks that the given default track of a snap is
+// a track name and not a channel. An empty track means there is none.
+func ValidateDefaultTrack(track string) error {
+	if track == "" {
+		return nil
+	}
+	if strings.Contains(track, "/") {
+		return fmt.Errorf("invalid default track %q: must be a track name, not a channel", track)
+	}
+	if strutil.ListContains(channelRisks, track) {
+		return fmt.Errorf("invalid default track %q: must be a track name, not a risk", track)
+	}
+	if len(track) > maxTrackLength {
+		return fmt.Errorf("invalid default track %q: cannot be longer than %d characters", track, maxTrackLength)
+	}
+	if !validTrack.MatchString(track) {
+		return fmt.Errorf("invalid default track %q: must be made of ASCII letters and digits, optionally separated by '.', '-' or '_'", track)
+	}
+	return nil
+}
+
 // ParseChannelVerbatim parses a string representing a store channel and
 // includes the given architecture, if architecture is "" the system
 // architecture is included. The channel representation is not normalized.
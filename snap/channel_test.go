@@ -262,3 +262,26 @@ func (s *storeChannelSuite) TestMatch(c *C) {
 		c.Check(req.Match(&c1).String(), Equals, t.res)
 	}
 }
+
+func (s storeChannelSuite) TestValidateDefaultTrack(c *C) {
+	for _, track := range []string{"", "latest", "1.0", "2", "insider-preview", "v1_2", "a23456789012345678901234567x"} {
+		c.Check(snap.ValidateDefaultTrack(track), IsNil, Commentf(track))
+	}
+
+	for _, t := range []struct {
+		track string
+		err   string
+	}{
+		{"latest/stable", `invalid default track "latest/stable": must be a track name, not a channel`},
+		{"1.0/edge/fix", `invalid default track "1.0/edge/fix": must be a track name, not a channel`},
+		{"stable", `invalid default track "stable": must be a track name, not a risk`},
+		{"edge", `invalid default track "edge": must be a track name, not a risk`},
+		{"a234567890123456789012345678x", `invalid default track "a234567890123456789012345678x": cannot be longer than 28 characters`},
+		{"-1.0", `invalid default track "-1.0": must be made of ASCII letters and digits, .*`},
+		{"1..0", `invalid default track "1..0": must be made of ASCII letters and digits, .*`},
+		{"1.0 ", `invalid default track "1.0 ": must be made of ASCII letters and digits, .*`},
+		{"trâck", `invalid default track "trâck": must be made of ASCII letters and digits, .*`},
+	} {
+		c.Check(snap.ValidateDefaultTrack(t.track), ErrorMatches, t.err, Commentf(t.track))
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/snapcore/snapd/osutil"
@@ -135,6 +136,8 @@ func ValidateContainer(c Container, s *Info, logf func(format string, v ...inter
 			// XXX maybe have a method on app to keep this in sync
 			paths = append(paths, app.StopCommand, app.ReloadCommand, app.PostStopCommand)
 		}
+		// the command-chain is run the same way as the command itself
+		paths = append(paths, app.CommandChain...)
 
 		for _, path := range paths {
 			path = normPath(path)
@@ -159,6 +162,22 @@ func ValidateContainer(c Container, s *Info, logf func(format string, v ...inter
 			}
 		}
 	}
+	// hooks are run as root, so their command-chain only needs to be
+	// executable
+	for _, hook := range s.Hooks {
+		for _, path := range hook.CommandChain {
+			path = normPath(path)
+			if path == "" {
+				continue
+			}
+
+			needsf[path] = true
+			needsx[path] = true
+			for ; path != "."; path = filepath.Dir(path) {
+				noskipd[path] = true
+			}
+		}
+	}
 	// note all needsr so far need to be regular files (or symlinks)
 	for k := range needsr {
 		needsf[k] = true
@@ -236,13 +255,19 @@ func ValidateContainer(c Container, s *Info, logf func(format string, v ...inter
 		return err
 	}
 	if len(seen) != len(needsx)+len(needsrx)+len(needsr) {
+		// report all the missing paths, in a stable order
+		var missing []string
 		for _, needs := range []map[string]bool{needsx, needsrx, needsr} {
 			for path := range needs {
 				if !seen[path] {
-					logf("in snap %q: path %q does not exist", s.InstanceName(), path)
+					missing = append(missing, path)
 				}
 			}
 		}
+		sort.Strings(missing)
+		for _, path := range missing {
+			logf("in snap %q: path %q does not exist", s.InstanceName(), path)
+		}
 		return ErrMissingPaths
 	}
 
@@ -20,6 +20,7 @@
 package snap_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -355,6 +356,112 @@ apps:
 	c.Check(err, IsNil)
 }
 
+func (s *validateSuite) TestValidateContainerMissingCommandChainFails(c *C) {
+	const yaml = `name: empty-snap
+version: 1
+apps:
+ foo:
+  command: foo
+  command-chain: [chain/runner]
+`
+	d := emptyContainer(c)
+	c.Assert(ioutil.WriteFile(filepath.Join(d.Path(), "foo"), nil, 0555), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(d.Path(), "chain"), 0755), IsNil)
+
+	// snapdir contains the app, but not its command-chain
+
+	info, err := snap.InfoFromSnapYaml([]byte(yaml))
+	c.Assert(err, IsNil)
+
+	var logged []string
+	logf := func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}
+	err = snap.ValidateContainer(d, info, logf)
+	c.Check(err, Equals, snap.ErrMissingPaths)
+	c.Check(logged, DeepEquals, []string{`in snap "empty-snap": path "chain/runner" does not exist`})
+}
+
+func (s *validateSuite) TestValidateContainerMissingPathsAllReported(c *C) {
+	const yaml = `name: empty-snap
+version: 1
+apps:
+ foo:
+  command: bin/foo --with args
+  completer: lib/foo-completion.bash
+ svc:
+  command: svc
+  daemon: simple
+  stop-command: bin/svc-stop
+  command-chain: [chain/runner]
+`
+	d := emptyContainer(c)
+	c.Assert(os.Mkdir(filepath.Join(d.Path(), "bin"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(d.Path(), "svc"), nil, 0755), IsNil)
+
+	info, err := snap.InfoFromSnapYaml([]byte(yaml))
+	c.Assert(err, IsNil)
+
+	var logged []string
+	logf := func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}
+	err = snap.ValidateContainer(d, info, logf)
+	c.Check(err, Equals, snap.ErrMissingPaths)
+	c.Check(logged, DeepEquals, []string{
+		`in snap "empty-snap": path "bin/foo" does not exist`,
+		`in snap "empty-snap": path "bin/svc-stop" does not exist`,
+		`in snap "empty-snap": path "chain/runner" does not exist`,
+		`in snap "empty-snap": path "lib" does not exist`,
+		`in snap "empty-snap": path "lib/foo-completion.bash" does not exist`,
+	})
+}
+
+func (s *validateSuite) TestValidateContainerBadCommandChainPermsFails(c *C) {
+	const yaml = `name: empty-snap
+version: 1
+apps:
+ foo:
+  command: foo
+  command-chain: [chain/runner]
+`
+	d := emptyContainer(c)
+	c.Assert(ioutil.WriteFile(filepath.Join(d.Path(), "foo"), nil, 0555), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(d.Path(), "chain"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(d.Path(), "chain", "runner"), nil, 0444), IsNil)
+
+	// snapdir contains the command-chain, but it is not executable
+
+	info, err := snap.InfoFromSnapYaml([]byte(yaml))
+	c.Assert(err, IsNil)
+
+	err = snap.ValidateContainer(d, info, discard)
+	c.Check(err, Equals, snap.ErrBadModes)
+}
+
+func (s *validateSuite) TestValidateContainerHookCommandChain(c *C) {
+	const yaml = `name: empty-snap
+version: 1
+hooks:
+ configure:
+  command-chain: [chain/runner]
+`
+	d := emptyContainer(c)
+
+	info, err := snap.InfoFromSnapYaml([]byte(yaml))
+	c.Assert(err, IsNil)
+
+	err = snap.ValidateContainer(d, info, discard)
+	c.Check(err, Equals, snap.ErrMissingPaths)
+
+	// root-only directories are fine for hooks
+	c.Assert(os.Mkdir(filepath.Join(d.Path(), "chain"), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(d.Path(), "chain", "runner"), nil, 0500), IsNil)
+
+	err = snap.ValidateContainer(d, info, discard)
+	c.Check(err, IsNil)
+}
+
 func (s *validateSuite) TestValidateContainerAppsOK(c *C) {
 	const yaml = `name: empty-snap
 version: 1
@@ -19,7 +19,10 @@
 
 package snap
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 type AlreadyInstalledError struct {
 	Snap string
@@ -48,3 +51,30 @@ type NotSnapError struct {
 func (e NotSnapError) Error() string {
 	return fmt.Sprintf("%q is not a snap or snapdir", e.Path)
 }
+
+// YamlParseError is returned by ValidateYaml when the snap.yaml cannot be
+// read into an Info.
+type YamlParseError struct {
+	Err error
+}
+
+func (e YamlParseError) Error() string {
+	return e.Err.Error()
+}
+
+// YamlValidationError is returned by ValidateYaml with all the problems
+// found in a snap.yaml that could be parsed.
+type YamlValidationError struct {
+	Errs []error
+}
+
+func (e YamlValidationError) Error() string {
+	if len(e.Errs) == 1 {
+		return e.Errs[0].Error()
+	}
+	var buf bytes.Buffer
+	for _, err := range e.Errs {
+		fmt.Fprintf(&buf, "\n- %s", err)
+	}
+	return fmt.Sprintf("invalid snap.yaml:%s", buf.Bytes())
+}
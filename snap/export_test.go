@@ -21,6 +21,7 @@ package snap
 
 var (
 	ValidateSocketName           = validateSocketName
+	ValidateAppSocketScopes      = validateAppSocketScopes
 	ValidateDescription          = validateDescription
 	ValidateTitle                = validateTitle
 	InfoFromSnapYamlWithSideInfo = infoFromSnapYamlWithSideInfo
@@ -33,3 +34,7 @@ func (info *Info) ForceRenamePlug(oldName, newName string) {
 func NewScopedTracker() *scopedTracker {
 	return new(scopedTracker)
 }
+
+func SocketAddressKey(socket *SocketInfo, fieldName, address string) string {
+	return socketAddressFor(socket, fieldName, address).key
+}
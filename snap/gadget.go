@@ -21,6 +21,7 @@ package snap
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/snapcore/snapd/gadget"
 )
@@ -41,3 +42,28 @@ func ReadGadgetInfo(info *Info, classic bool) (*gadget.Info, error) {
 	}
 	return gi, nil
 }
+
+// ValidateGadgetDefaults checks that the defaults of the gadget are all for
+// "system" or for one of the given snaps, e.g. the ones seeded along the
+// gadget. Defaults for other snaps are silently ignored when seeding, so
+// they are most likely a mistake.
+func ValidateGadgetDefaults(gi *gadget.Info, snaps []*Info) error {
+	known := make(map[string]bool, len(snaps))
+	for _, info := range snaps {
+		if info.SnapID != "" {
+			known[info.SnapID] = true
+		}
+	}
+
+	keys := make([]string, 0, len(gi.Defaults))
+	for key := range gi.Defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key != "system" && !known[key] {
+			return fmt.Errorf("gadget defaults reference snap-id %q which is not one of the snaps", key)
+		}
+	}
+	return nil
+}
@@ -79,3 +79,29 @@ func (s *gadgetYamlTestSuite) TestReadGadgetYamlOnClassicEmptyIsValid(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(ginfo, DeepEquals, &gadget.Info{})
 }
+
+func (s *gadgetYamlTestSuite) TestValidateGadgetDefaults(c *C) {
+	snaps := []*snap.Info{
+		{SideInfo: snap.SideInfo{RealName: "foo", SnapID: "foo-id"}},
+		{SideInfo: snap.SideInfo{RealName: "bar", SnapID: "bar-id"}},
+		// unasserted
+		{SideInfo: snap.SideInfo{RealName: "baz"}},
+	}
+
+	gi := &gadget.Info{
+		Defaults: map[string]map[string]interface{}{
+			"system": {"service.rsyslog.disable": true},
+			"foo-id": {"key": "value"},
+		},
+	}
+	c.Check(snap.ValidateGadgetDefaults(gi, snaps), IsNil)
+	c.Check(snap.ValidateGadgetDefaults(&gadget.Info{}, nil), IsNil)
+
+	gi.Defaults["other-id"] = map[string]interface{}{"key": "value"}
+	gi.Defaults["another-id"] = map[string]interface{}{"key": "value"}
+	c.Check(snap.ValidateGadgetDefaults(gi, snaps), ErrorMatches, `gadget defaults reference snap-id "another-id" which is not one of the snaps`)
+
+	// defaults cannot be keyed by empty snap-ids of unasserted snaps
+	gi.Defaults = map[string]map[string]interface{}{"": {"key": "value"}}
+	c.Check(snap.ValidateGadgetDefaults(gi, snaps), ErrorMatches, `gadget defaults reference snap-id "" which is not one of the snaps`)
+}
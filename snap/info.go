@@ -225,6 +225,16 @@ type Info struct {
 	Plugs            map[string]*PlugInfo
 	Slots            map[string]*SlotInfo
 
+	// SystemUsernames lists the system users declared by the snap
+	SystemUsernames map[string]*SystemUsernameInfo
+
+	// SnapProvenance is the provenance declared by the snap, see
+	// Provenance
+	SnapProvenance string
+
+	// Links maps kinds of links, like "website" or "contact", to URLs
+	Links map[string][]string
+
 	// Plugs or slots with issues (they are not included in Plugs or Slots)
 	BadInterfaces map[string]string // slot or plug => message
 
@@ -277,6 +287,7 @@ type Layout struct {
 	Group    string      `json:"group,omitempty"`
 	Mode     os.FileMode `json:"mode,omitempty"`
 	Symlink  string      `json:"symlink,omitempty"`
+	Options  []string    `json:"options,omitempty"`
 }
 
 // String returns a simple textual representation of a layout.
@@ -304,6 +315,9 @@ func (l *Layout) String() string {
 	if l.Mode != 0755 {
 		fmt.Fprintf(&buf, ", mode: %#o", l.Mode)
 	}
+	if len(l.Options) > 0 {
+		fmt.Fprintf(&buf, ", options: %s", strings.Join(l.Options, ","))
+	}
 	return buf.String()
 }
 
@@ -341,6 +355,18 @@ func (s *Info) Title() string {
 	return s.OriginalTitle
 }
 
+// DefaultProvenance is the provenance of snaps that do not declare one.
+const DefaultProvenance = "global-upload"
+
+// Provenance returns the provenance of the snap, DefaultProvenance if
+// the snap does not declare one.
+func (s *Info) Provenance() string {
+	if s.SnapProvenance != "" {
+		return s.SnapProvenance
+	}
+	return DefaultProvenance
+}
+
 // Summary returns the blessed summary for the snap.
 func (s *Info) Summary() string {
 	if s.EditedSummary != "" {
@@ -669,9 +695,10 @@ type SlotInfo struct {
 type SocketInfo struct {
 	App *AppInfo
 
-	Name         string
-	ListenStream string
-	SocketMode   os.FileMode
+	Name           string
+	ListenStream   string
+	ListenDatagram string
+	SocketMode     os.FileMode
 }
 
 // TimerInfo provides information on application timer.
@@ -681,6 +708,14 @@ type TimerInfo struct {
 	Timer string
 }
 
+// SystemUsernameInfo provides information about a system username
+// declared by the snap.
+type SystemUsernameInfo struct {
+	Name  string
+	Scope string
+	Attrs map[string]interface{}
+}
+
 // StopModeType is the type for the "stop-mode:" of a snap app
 type StopModeType string
 
@@ -708,6 +743,16 @@ func (st StopModeType) Validate() error {
 	return fmt.Errorf(`"stop-mode" field contains invalid value %q`, st)
 }
 
+// DaemonScope represents the scope of the daemon running under systemd
+type DaemonScope string
+
+const (
+	// SystemDaemon is a daemon run by the system instance of systemd
+	SystemDaemon DaemonScope = "system"
+	// UserDaemon is a daemon run by the per-user instance of systemd
+	UserDaemon DaemonScope = "user"
+)
+
 // AppInfo provides information about an app.
 type AppInfo struct {
 	Snap *Info
@@ -717,8 +762,12 @@ type AppInfo struct {
 	Command       string
 	CommandChain  []string
 	CommonID      string
+	// Desktop is the name of the desktop file in meta/gui/ used to
+	// launch the app, it is installed as <snap>_<name>.desktop
+	Desktop string
 
 	Daemon          string
+	DaemonScope     DaemonScope
 	StopTimeout     timeout.Timeout
 	StartTimeout    timeout.Timeout
 	WatchdogTimeout timeout.Timeout
@@ -729,6 +778,7 @@ type AppInfo struct {
 	RestartDelay    timeout.Timeout
 	Completer       string
 	RefreshMode     string
+	InstallMode     string
 	StopMode        StopModeType
 
 	// TODO: this should go away once we have more plumbing and can change
@@ -740,6 +790,9 @@ type AppInfo struct {
 	Slots   map[string]*SlotInfo
 	Sockets map[string]*SocketInfo
 
+	// ActivatesOn lists the slots on which the service is activated
+	ActivatesOn []*SlotInfo
+
 	Environment strutil.OrderedMap
 
 	// list of other service names that this service will start after or
@@ -1161,14 +1214,18 @@ func SortServices(apps []*AppInfo) (sorted []*AppInfo, err error) {
 	successors := make(map[string][]*AppInfo, len(apps))
 	// count of predecessors (i.e. incoming edges) of given app
 	predecessors := make(map[string]int, len(apps))
+	// names of the predecessors of given app, used to report cycles
+	predecessorNames := make(map[string][]string, len(apps))
 
 	for _, app := range apps {
 		for _, other := range app.After {
 			predecessors[app.Name]++
+			predecessorNames[app.Name] = append(predecessorNames[app.Name], other)
 			successors[other] = append(successors[other], app)
 		}
 		for _, other := range app.Before {
 			predecessors[other]++
+			predecessorNames[other] = append(predecessorNames[other], app.Name)
 			successors[app.Name] = append(successors[app.Name], nameToApp[other])
 		}
 	}
@@ -1206,6 +1263,9 @@ func SortServices(apps []*AppInfo) (sorted []*AppInfo, err error) {
 	if len(predecessors) != 0 {
 		// apps with predecessors unaccounted for are a part of
 		// dependency cycle
+		if cycle := findServicesCycle(predecessors, predecessorNames); cycle != nil {
+			return nil, fmt.Errorf("applications are part of a before/after cycle: %s", strings.Join(cycle, " -> "))
+		}
 		unsatisifed := bytes.Buffer{}
 		for name := range predecessors {
 			if unsatisifed.Len() > 0 {
@@ -1217,3 +1277,56 @@ func SortServices(apps []*AppInfo) (sorted []*AppInfo, err error) {
 	}
 	return sorted, nil
 }
+
+// findServicesCycle returns the apps forming one of the cycles among the
+// apps that could not be sorted, in start order and starting and ending with
+// the same app, e.g. [a b c a].
+func findServicesCycle(unsorted map[string]int, predecessorNames map[string][]string) []string {
+	names := make([]string, 0, len(unsorted))
+	for name := range unsorted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// every unsorted app has an unsorted predecessor, so walking them
+	// backwards eventually visits an app twice
+	var path []string
+	visited := make(map[string]int, len(names))
+	name := names[0]
+	for {
+		if idx, ok := visited[name]; ok {
+			path = path[idx:]
+			break
+		}
+		visited[name] = len(path)
+		path = append(path, name)
+
+		next := ""
+		for _, pred := range predecessorNames[name] {
+			if _, ok := unsorted[pred]; ok {
+				next = pred
+				break
+			}
+		}
+		if next == "" {
+			// predecessor is not a known app
+			return nil
+		}
+		name = next
+	}
+
+	// reverse into start order, beginning with the lowest name
+	start := 0
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	for i := range path {
+		if path[i] < path[start] {
+			start = i
+		}
+	}
+	cycle := make([]string, 0, len(path)+1)
+	cycle = append(cycle, path[start:]...)
+	cycle = append(cycle, path[:start]...)
+	return append(cycle, cycle[0])
+}
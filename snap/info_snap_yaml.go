@@ -43,6 +43,7 @@ type snapYaml struct {
 	Description   string                 `yaml:"description"`
 	Summary       string                 `yaml:"summary"`
 	License       string                 `yaml:"license,omitempty"`
+	Links         map[string][]string    `yaml:"links,omitempty"`
 	Epoch         Epoch                  `yaml:"epoch,omitempty"`
 	Base          string                 `yaml:"base,omitempty"`
 	Confinement   ConfinementType        `yaml:"confinement,omitempty"`
@@ -53,6 +54,12 @@ type snapYaml struct {
 	Hooks         map[string]hookYaml    `yaml:"hooks,omitempty"`
 	Layout        map[string]layoutYaml  `yaml:"layout,omitempty"`
 
+	SystemUsernames map[string]interface{} `yaml:"system-usernames,omitempty"`
+
+	// Provenance is a pointer so that an explicitly empty value can
+	// be told apart from an unset one
+	Provenance *string `yaml:"provenance,omitempty"`
+
 	// TypoLayouts is used to detect the use of the incorrect plural form of "layout"
 	TypoLayouts typoDetector `yaml:"layouts,omitempty"`
 }
@@ -71,7 +78,8 @@ type appYaml struct {
 	Command      string   `yaml:"command"`
 	CommandChain []string `yaml:"command-chain,omitempty"`
 
-	Daemon string `yaml:"daemon"`
+	Daemon      string      `yaml:"daemon"`
+	DaemonScope DaemonScope `yaml:"daemon-scope,omitempty"`
 
 	StopCommand     string          `yaml:"stop-command,omitempty"`
 	ReloadCommand   string          `yaml:"reload-command,omitempty"`
@@ -81,6 +89,7 @@ type appYaml struct {
 	WatchdogTimeout timeout.Timeout `yaml:"watchdog-timeout,omitempty"`
 	Completer       string          `yaml:"completer,omitempty"`
 	RefreshMode     string          `yaml:"refresh-mode,omitempty"`
+	InstallMode     string          `yaml:"install-mode,omitempty"`
 	StopMode        StopModeType    `yaml:"stop-mode,omitempty"`
 
 	RestartCond  RestartCondition `yaml:"restart-condition,omitempty"`
@@ -90,11 +99,14 @@ type appYaml struct {
 
 	BusName  string `yaml:"bus-name,omitempty"`
 	CommonID string `yaml:"common-id,omitempty"`
+	Desktop  string `yaml:"desktop,omitempty"`
 
 	Environment strutil.OrderedMap `yaml:"environment,omitempty"`
 
 	Sockets map[string]socketsYaml `yaml:"sockets,omitempty"`
 
+	ActivatesOn []string `yaml:"activates-on,omitempty"`
+
 	After  []string `yaml:"after,omitempty"`
 	Before []string `yaml:"before,omitempty"`
 
@@ -111,18 +123,20 @@ type hookYaml struct {
 }
 
 type layoutYaml struct {
-	Bind     string `yaml:"bind,omitempty"`
-	BindFile string `yaml:"bind-file,omitempty"`
-	Type     string `yaml:"type,omitempty"`
-	User     string `yaml:"user,omitempty"`
-	Group    string `yaml:"group,omitempty"`
-	Mode     string `yaml:"mode,omitempty"`
-	Symlink  string `yaml:"symlink,omitempty"`
+	Bind     string   `yaml:"bind,omitempty"`
+	BindFile string   `yaml:"bind-file,omitempty"`
+	Type     string   `yaml:"type,omitempty"`
+	User     string   `yaml:"user,omitempty"`
+	Group    string   `yaml:"group,omitempty"`
+	Mode     string   `yaml:"mode,omitempty"`
+	Symlink  string   `yaml:"symlink,omitempty"`
+	Options  []string `yaml:"options,omitempty"`
 }
 
 type socketsYaml struct {
-	ListenStream string      `yaml:"listen-stream,omitempty"`
-	SocketMode   os.FileMode `yaml:"socket-mode,omitempty"`
+	ListenStream   string      `yaml:"listen-stream,omitempty"`
+	ListenDatagram string      `yaml:"listen-datagram,omitempty"`
+	SocketMode     os.FileMode `yaml:"socket-mode,omitempty"`
 }
 
 // InfoFromSnapYaml creates a new info based on the given snap.yaml data
@@ -189,6 +203,18 @@ func infoFromSnapYaml(yamlData []byte, strk *scopedTracker) (*Info, error) {
 	bindUnscopedPlugs(snap, strk)
 	bindUnscopedSlots(snap, strk)
 
+	// Collect system usernames
+	if err := setSystemUsernamesFromSnapYaml(y, snap); err != nil {
+		return nil, err
+	}
+
+	if y.Provenance != nil {
+		if *y.Provenance == "" {
+			return nil, fmt.Errorf("provenance cannot be empty, omit it to use the default")
+		}
+		snap.SnapProvenance = *y.Provenance
+	}
+
 	// Collect layout elements.
 	if y.Layout != nil {
 		snap.Layout = make(map[string]*Layout, len(y.Layout))
@@ -212,7 +238,7 @@ func infoFromSnapYaml(yamlData []byte, strk *scopedTracker) (*Info, error) {
 			snap.Layout[path] = &Layout{
 				Snap: snap, Path: path,
 				Bind: l.Bind, Type: l.Type, Symlink: l.Symlink, BindFile: l.BindFile,
-				User: user, Group: group, Mode: mode,
+				User: user, Group: group, Mode: mode, Options: l.Options,
 			}
 		}
 	}
@@ -266,6 +292,7 @@ func infoSkeletonFromSnapYaml(y snapYaml) *Info {
 		OriginalDescription: y.Description,
 		OriginalSummary:     y.Summary,
 		License:             y.License,
+		Links:               y.Links,
 		Epoch:               y.Epoch,
 		Confinement:         confinement,
 		Base:                y.Base,
@@ -341,6 +368,7 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 			CommandChain:    yApp.CommandChain,
 			StartTimeout:    yApp.StartTimeout,
 			Daemon:          yApp.Daemon,
+			DaemonScope:     yApp.DaemonScope,
 			StopTimeout:     yApp.StopTimeout,
 			StopCommand:     yApp.StopCommand,
 			ReloadCommand:   yApp.ReloadCommand,
@@ -349,10 +377,12 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 			RestartDelay:    yApp.RestartDelay,
 			BusName:         yApp.BusName,
 			CommonID:        yApp.CommonID,
+			Desktop:         yApp.Desktop,
 			Environment:     yApp.Environment,
 			Completer:       yApp.Completer,
 			StopMode:        yApp.StopMode,
 			RefreshMode:     yApp.RefreshMode,
+			InstallMode:     yApp.InstallMode,
 			Before:          yApp.Before,
 			After:           yApp.After,
 			Autostart:       yApp.Autostart,
@@ -409,12 +439,27 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 			app.Slots[slotName] = slot
 			slot.Apps[appName] = app
 		}
+		for _, slotName := range yApp.ActivatesOn {
+			slot, ok := snap.Slots[slotName]
+			if !ok {
+				return fmt.Errorf("invalid activates-on value %q on application %q: slot not found", slotName, appName)
+			}
+			app.ActivatesOn = append(app.ActivatesOn, slot)
+			// activation implies the app provides the slot
+			if app.Slots == nil {
+				app.Slots = make(map[string]*SlotInfo)
+			}
+			strk.markSlot(slot)
+			app.Slots[slotName] = slot
+			slot.Apps[appName] = app
+		}
 		for name, data := range yApp.Sockets {
 			app.Sockets[name] = &SocketInfo{
-				App:          app,
-				Name:         name,
-				ListenStream: data.ListenStream,
-				SocketMode:   data.SocketMode,
+				App:            app,
+				Name:           name,
+				ListenStream:   data.ListenStream,
+				ListenDatagram: data.ListenDatagram,
+				SocketMode:     data.SocketMode,
 			}
 		}
 		if yApp.Timer != "" {
@@ -431,6 +476,71 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 	return nil
 }
 
+func setSystemUsernamesFromSnapYaml(y snapYaml, snap *Info) error {
+	if len(y.SystemUsernames) == 0 {
+		return nil
+	}
+	snap.SystemUsernames = make(map[string]*SystemUsernameInfo, len(y.SystemUsernames))
+	for user, data := range y.SystemUsernames {
+		if user == "" {
+			return fmt.Errorf("system username cannot be empty")
+		}
+		scope, attrs, err := convertToUsernamesData(user, data)
+		if err != nil {
+			return err
+		}
+		snap.SystemUsernames[user] = &SystemUsernameInfo{
+			Name:  user,
+			Scope: scope,
+			Attrs: attrs,
+		}
+	}
+	return nil
+}
+
+// convertToUsernamesData takes either a scope string, or a map with the
+// scope and additional attributes of a system username.
+func convertToUsernamesData(user string, data interface{}) (scope string, attrs map[string]interface{}, err error) {
+	switch data.(type) {
+	case string:
+		return data.(string), nil, nil
+	case nil:
+		return "", nil, nil
+	case map[interface{}]interface{}:
+		for keyData, valueData := range data.(map[interface{}]interface{}) {
+			key, ok := keyData.(string)
+			if !ok {
+				err := fmt.Errorf("system username %q has attribute key that is not a string (found %T)", user, keyData)
+				return "", nil, err
+			}
+			switch key {
+			case "scope":
+				value, ok := valueData.(string)
+				if !ok {
+					err := fmt.Errorf("scope on system username %q is not a string (found %T)", user, valueData)
+					return "", nil, err
+				}
+				scope = value
+			case "":
+				return "", nil, fmt.Errorf("system username %q has an empty attribute key", user)
+			default:
+				if attrs == nil {
+					attrs = make(map[string]interface{})
+				}
+				value, err := metautil.NormalizeValue(valueData)
+				if err != nil {
+					return "", nil, fmt.Errorf("attribute %q of system username %q: %v", key, user, err)
+				}
+				attrs[key] = value
+			}
+		}
+		return scope, attrs, nil
+	default:
+		err := fmt.Errorf("system username %q has malformed definition (found %T)", user, data)
+		return "", nil, err
+	}
+}
+
 func setHooksFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) {
 	for hookName, yHook := range y.Hooks {
 		if !IsHookSupported(hookName) {
@@ -1504,6 +1504,76 @@ apps:
 	})
 }
 
+func (s *YamlSuite) TestDaemonListenDatagram(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ svc:
+   command: svc
+   sockets:
+     sock:
+       listen-datagram: 127.0.0.1:5353
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+
+	app := snap.AppInfo{
+		Snap:    info,
+		Name:    "svc",
+		Command: "svc",
+		Sockets: map[string]*snap.SocketInfo{},
+	}
+
+	app.Sockets["sock"] = &snap.SocketInfo{
+		App:            &app,
+		Name:           "sock",
+		ListenDatagram: "127.0.0.1:5353",
+	}
+
+	c.Check(info.Apps, DeepEquals, map[string]*snap.AppInfo{
+		"svc": &app,
+	})
+}
+
+func (s *YamlSuite) TestDaemonActivatesOn(c *C) {
+	y := []byte(`name: wat
+version: 42
+slots:
+ dbus-slot:
+  interface: dbus
+  bus: system
+  name: org.example.wat
+apps:
+ svc:
+   command: svc
+   daemon: dbus
+   bus-name: org.example.wat
+   activates-on: [dbus-slot]
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+
+	app := info.Apps["svc"]
+	slot := info.Slots["dbus-slot"]
+	c.Check(app.ActivatesOn, DeepEquals, []*snap.SlotInfo{slot})
+	// the slot is implicitly bound to the app
+	c.Check(app.Slots["dbus-slot"], Equals, slot)
+	c.Check(slot.Apps["svc"], Equals, app)
+}
+
+func (s *YamlSuite) TestDaemonActivatesOnUnknownSlot(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ svc:
+   command: svc
+   daemon: dbus
+   activates-on: [dbus-slot]
+`)
+	_, err := snap.InfoFromSnapYaml(y)
+	c.Check(err, ErrorMatches, `invalid activates-on value "dbus-slot" on application "svc": slot not found`)
+}
+
 func (s *YamlSuite) TestDaemonInvalidSocketMode(c *C) {
 	y := []byte(`name: wat
 version: 42
@@ -1611,6 +1681,42 @@ apps:
 	c.Assert(err, ErrorMatches, `cannot set "bar" as alias for both ("foo" and "bar"|"bar" and "foo")`)
 }
 
+func (s *YamlSuite) TestSnapYamlSystemUsernames(c *C) {
+	y := []byte(`name: binary
+version: 1.0
+system-usernames:
+  foo: shared
+  bar:
+    scope: external
+    attr: value
+  baz:
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.SystemUsernames, DeepEquals, map[string]*snap.SystemUsernameInfo{
+		"foo": {Name: "foo", Scope: "shared"},
+		"bar": {Name: "bar", Scope: "external", Attrs: map[string]interface{}{"attr": "value"}},
+		"baz": {Name: "baz"},
+	})
+}
+
+func (s *YamlSuite) TestSnapYamlSystemUsernamesErrors(c *C) {
+	for _, t := range []struct {
+		yaml string
+		err  string
+	}{
+		{"foo: [1, 2]", `system username "foo" has malformed definition \(found \[\]interface {}\)`},
+		{"foo:\n    scope: [1]", `scope on system username "foo" is not a string \(found \[\]interface {}\)`},
+		{"foo:\n    1: bar", `system username "foo" has attribute key that is not a string \(found int\)`},
+		{"foo:\n    '': bar", `system username "foo" has an empty attribute key`},
+		{"'': shared", `system username cannot be empty`},
+	} {
+		y := []byte("name: binary\nversion: 1.0\nsystem-usernames:\n  " + t.yaml + "\n")
+		_, err := snap.InfoFromSnapYaml(y)
+		c.Check(err, ErrorMatches, t.err, Commentf(t.yaml))
+	}
+}
+
 func (s *YamlSuite) TestSnapYamlAppStartOrder(c *C) {
 	y := []byte(`name: wat
 version: 42
@@ -1704,6 +1810,29 @@ layout:
 	})
 }
 
+func (s *YamlSuite) TestLayoutOptions(c *C) {
+	y := []byte(`
+name: foo
+version: 1.0
+layout:
+  /usr/share/foo:
+    bind: $SNAP/usr/share/foo
+    options: [ro]
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Assert(info.Layout["/usr/share/foo"], DeepEquals, &snap.Layout{
+		Snap:    info,
+		Path:    "/usr/share/foo",
+		Bind:    "$SNAP/usr/share/foo",
+		User:    "root",
+		Group:   "root",
+		Mode:    0755,
+		Options: []string{"ro"},
+	})
+	c.Check(info.Layout["/usr/share/foo"].String(), Equals, "/usr/share/foo: bind $SNAP/usr/share/foo, options: ro")
+}
+
 func (s *YamlSuite) TestLayoutsWithTypo(c *C) {
 	y := []byte(`
 name: foo
@@ -1785,6 +1914,56 @@ apps:
 		true)
 }
 
+func (s *YamlSuite) TestSnapYamlAppDaemonScope(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ foo:
+   daemon: simple
+   daemon-scope: user
+ bar:
+   daemon: simple
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.Apps["foo"].DaemonScope, Equals, snap.UserDaemon)
+	c.Check(info.Apps["bar"].DaemonScope, Equals, snap.DaemonScope(""))
+}
+
+func (s *YamlSuite) TestSnapYamlAppInstallMode(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ foo:
+   command: bin/foo
+   daemon: simple
+   install-mode: disable
+ bar:
+   command: bin/bar
+   daemon: simple
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.Apps["foo"].InstallMode, Equals, "disable")
+	c.Check(info.Apps["bar"].InstallMode, Equals, "")
+}
+
+func (s *YamlSuite) TestSnapYamlAppDesktop(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ foo:
+   command: bin/foo
+   desktop: foo.desktop
+ bar:
+   command: bin/bar
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.Apps["foo"].Desktop, Equals, "foo.desktop")
+	c.Check(info.Apps["bar"].Desktop, Equals, "")
+}
+
 func (s *YamlSuite) TestSnapYamlCommandChain(c *C) {
 	yAutostart := []byte(`name: wat
 version: 42
@@ -23,6 +23,7 @@ package naming
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -79,10 +80,157 @@ func ValidateInstance(instanceName string) error {
 func ValidateSnap(name string) error {
 	// NOTE: This function should be synchronized with the two other
 	// implementations: sc_snap_name_validate and validate_snap_name .
-	if len(name) < 2 || len(name) > 40 || !isValidName(name) {
-		return fmt.Errorf("invalid snap name: %q", name)
+	return ValidateSnapDetailed(name)
+}
+
+// SnapNameRule identifies a rule of the snap name grammar.
+type SnapNameRule int
+
+const (
+	// SnapNameTooShort is broken by names shorter than 2 characters.
+	SnapNameTooShort SnapNameRule = iota + 1
+	// SnapNameTooLong is broken by names longer than 40 characters.
+	SnapNameTooLong
+	// SnapNameUppercase is broken by names with uppercase letters.
+	SnapNameUppercase
+	// SnapNameInvalidChars is broken by names with characters other
+	// than lowercase letters, digits and dashes.
+	SnapNameInvalidChars
+	// SnapNameNoLetter is broken by names without any letter, e.g.
+	// names that are only digits.
+	SnapNameNoLetter
+	// SnapNameEdgeDash is broken by names starting or ending with a dash.
+	SnapNameEdgeDash
+	// SnapNameDoubleDash is broken by names with consecutive dashes.
+	SnapNameDoubleDash
+	// SnapNameConfusable is broken by names with non-ASCII characters
+	// that look like allowed ones, e.g. a Cyrillic "а".
+	SnapNameConfusable
+)
+
+func (r SnapNameRule) String() string {
+	switch r {
+	case SnapNameTooShort:
+		return "must be at least 2 characters long"
+	case SnapNameTooLong:
+		return "must be at most 40 characters long"
+	case SnapNameUppercase:
+		return "cannot contain uppercase letters"
+	case SnapNameInvalidChars:
+		return "can only contain lowercase letters, digits and dashes"
+	case SnapNameNoLetter:
+		return "must contain at least one letter"
+	case SnapNameEdgeDash:
+		return "cannot start or end with a dash"
+	case SnapNameDoubleDash:
+		return "cannot contain consecutive dashes"
+	case SnapNameConfusable:
+		return "cannot contain non-ASCII characters that look like ASCII ones"
 	}
-	return nil
+	return fmt.Sprintf("SnapNameRule(%d)", int(r))
+}
+
+// InvalidSnapNameError is returned by ValidateSnapDetailed for names that
+// break a rule of the snap name grammar.
+type InvalidSnapNameError struct {
+	Name string
+	// Rule is the first rule the name breaks.
+	Rule SnapNameRule
+	// Char is the offending character for SnapNameConfusable.
+	Char rune
+}
+
+func (e *InvalidSnapNameError) Error() string {
+	if ascii, ok := confusableWith(e.Char); ok && e.Rule == SnapNameConfusable {
+		// the name is quoted as ASCII, otherwise the lookalike
+		// cannot be told apart
+		return fmt.Sprintf("invalid snap name: %s contains %U which looks like %q but is not ASCII",
+			strconv.QuoteToASCII(e.Name), e.Char, ascii)
+	}
+	return fmt.Sprintf("invalid snap name: %q", e.Name)
+}
+
+// confusables maps non-ASCII characters to the characters allowed in snap
+// names that they are commonly mistaken for. Fullwidth forms are handled
+// separately.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j',
+	'ӏ': 'l', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ԝ': 'w', 'х': 'x',
+	'у': 'y',
+	// Greek
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u',
+	// dashes
+	'\u2010': '-', '\u2011': '-', '\u2012': '-', '\u2013': '-', '\u2014': '-', '\u2212': '-',
+}
+
+// confusableWith returns the character allowed in snap names that r looks
+// like, if any.
+func confusableWith(r rune) (rune, bool) {
+	switch {
+	case r >= '\uff41' && r <= '\uff5a':
+		// fullwidth lowercase letters
+		return 'a' + (r - '\uff41'), true
+	case r >= '\uff10' && r <= '\uff19':
+		// fullwidth digits
+		return '0' + (r - '\uff10'), true
+	case r == '\uff0d':
+		return '-', true
+	}
+	ascii, ok := confusables[r]
+	return ascii, ok
+}
+
+// firstConfusable returns the first character of name that looks like one
+// allowed in snap names without being one, if those lookalikes are the
+// only characters of name that are not allowed. Names in other scripts
+// are just invalid, even if some of their letters look like ASCII ones.
+func firstConfusable(name string) (rune, bool) {
+	var first rune
+	for _, r := range name {
+		if r < 0x80 {
+			continue
+		}
+		if _, ok := confusableWith(r); !ok {
+			return 0, false
+		}
+		if first == 0 {
+			first = r
+		}
+	}
+	return first, first != 0
+}
+
+var hasUppercase = regexp.MustCompile("[A-Z]")
+
+// ValidateSnapDetailed checks if a string can be used as a snap name like
+// ValidateSnap does, but returns an *InvalidSnapNameError identifying the
+// rule that was broken.
+func ValidateSnapDetailed(name string) error {
+	var rule SnapNameRule
+	var char rune
+	switch {
+	case len(name) < 2:
+		rule = SnapNameTooShort
+	case len(name) > 40:
+		rule = SnapNameTooLong
+	case hasUppercase.MatchString(name):
+		rule = SnapNameUppercase
+	case strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-") != "":
+		rule = SnapNameInvalidChars
+		if r, ok := firstConfusable(name); ok {
+			rule, char = SnapNameConfusable, r
+		}
+	case !almostValidName.MatchString(name):
+		rule = SnapNameNoLetter
+	case name[0] == '-' || name[len(name)-1] == '-':
+		rule = SnapNameEdgeDash
+	case strings.Contains(name, "--"):
+		rule = SnapNameDoubleDash
+	default:
+		return nil
+	}
+	return &InvalidSnapNameError{Name: name, Rule: rule, Char: char}
 }
 
 // Regular expression describing correct plug, slot and interface names.
@@ -86,6 +86,72 @@ func (s *ValidateSuite) TestValidateName(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestValidateNameDetailed(c *C) {
+	c.Check(naming.ValidateSnapDetailed("a-0a"), IsNil)
+
+	for _, t := range []struct {
+		name string
+		rule naming.SnapNameRule
+	}{
+		{"", naming.SnapNameTooShort},
+		{"a", naming.SnapNameTooShort},
+		{"xxxxxxxxxxxxxxxxxxxx-xxxxxxxxxxxxxxxxxxxx", naming.SnapNameTooLong},
+		{"Foo", naming.SnapNameUppercase},
+		{"my Foo", naming.SnapNameUppercase},
+		{"a a", naming.SnapNameInvalidChars},
+		{"a_b", naming.SnapNameInvalidChars},
+		{"日本語", naming.SnapNameInvalidChars},
+		{"123", naming.SnapNameNoLetter},
+		{"--", naming.SnapNameNoLetter},
+		{"-a", naming.SnapNameEdgeDash},
+		{"a-", naming.SnapNameEdgeDash},
+		{"a--a", naming.SnapNameDoubleDash},
+	} {
+		err := naming.ValidateSnapDetailed(t.name)
+		c.Assert(err, FitsTypeOf, &naming.InvalidSnapNameError{}, Commentf(t.name))
+		c.Check(err.(*naming.InvalidSnapNameError).Rule, Equals, t.rule, Commentf(t.name))
+		c.Check(err, ErrorMatches, `invalid snap name: ".*"`)
+		// ValidateSnap returns the same error
+		c.Check(naming.ValidateSnap(t.name), DeepEquals, err)
+	}
+
+	c.Check(naming.SnapNameDoubleDash.String(), Equals, "cannot contain consecutive dashes")
+	c.Check(naming.SnapNameConfusable.String(), Equals, "cannot contain non-ASCII characters that look like ASCII ones")
+	c.Check(naming.SnapNameRule(42).String(), Equals, "SnapNameRule(42)")
+}
+
+func (s *ValidateSuite) TestValidateNameConfusable(c *C) {
+	for _, t := range []struct {
+		name string
+		char rune
+		err  string
+	}{
+		// Cyrillic "а"
+		{"\u0430pp", '\u0430', `invalid snap name: "\\u0430pp" contains U\+0430 which looks like 'a' but is not ASCII`},
+		// Greek "ο"
+		{"f\u03bfo", '\u03bf', `invalid snap name: "f\\u03bfo" contains U\+03BF which looks like 'o' but is not ASCII`},
+		// non-breaking hyphen
+		{"foo\u2011bar", '\u2011', `invalid snap name: "foo\\u2011bar" contains U\+2011 which looks like '-' but is not ASCII`},
+		// fullwidth "ｆ" and "１"
+		{"\uff46oo", '\uff46', `invalid snap name: "\\uff46oo" contains U\+FF46 which looks like 'f' but is not ASCII`},
+		{"foo\uff11", '\uff11', `invalid snap name: "foo\\uff11" contains U\+FF11 which looks like '1' but is not ASCII`},
+	} {
+		err := naming.ValidateSnap(t.name)
+		c.Assert(err, FitsTypeOf, &naming.InvalidSnapNameError{}, Commentf(t.name))
+		c.Check(err.(*naming.InvalidSnapNameError).Rule, Equals, naming.SnapNameConfusable, Commentf(t.name))
+		c.Check(err.(*naming.InvalidSnapNameError).Char, Equals, t.char, Commentf(t.name))
+		c.Check(err, ErrorMatches, t.err)
+	}
+
+	// other non-ASCII characters are just invalid
+	err := naming.ValidateSnap("f\u00f6o")
+	c.Check(err.(*naming.InvalidSnapNameError).Rule, Equals, naming.SnapNameInvalidChars)
+	c.Check(err, ErrorMatches, `invalid snap name: "föo"`)
+	// as are words of other scripts with some lookalike letters
+	err = naming.ValidateSnap("\u044f\u0437\u044b\u043a-\u0430")
+	c.Check(err.(*naming.InvalidSnapNameError).Rule, Equals, naming.SnapNameInvalidChars)
+}
+
 func (s *ValidateSuite) TestValidateInstanceName(c *C) {
 	validNames := []string{
 		// plain names are also valid instance names
@@ -20,16 +20,22 @@
 package snap
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 	"unicode/utf8"
 
+	"github.com/snapcore/snapd/osutil/sys"
 	"github.com/snapcore/snapd/snap/naming"
 	"github.com/snapcore/snapd/spdx"
 	"github.com/snapcore/snapd/strutil"
@@ -40,6 +46,19 @@ import (
 // The fixed length of valid snap IDs.
 const validSnapIDLength = 32
 
+// ValidateSnapID checks whether the string is a valid snap-id.
+func ValidateSnapID(id string) error {
+	if len(id) != validSnapIDLength {
+		return fmt.Errorf("invalid snap-id %q: must be %d characters long, got %d", id, validSnapIDLength, len(id))
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return fmt.Errorf("invalid snap-id %q: contains invalid character %q", id, r)
+		}
+	}
+	return nil
+}
+
 // ValidateInstanceName checks if a string can be used as a snap instance name.
 func ValidateInstanceName(instanceName string) error {
 	return naming.ValidateInstance(instanceName)
@@ -50,6 +69,38 @@ func ValidateName(name string) error {
 	return naming.ValidateSnap(name)
 }
 
+// ValidateNameDetailed checks if a string can be used as a snap name, like
+// ValidateName, returning a *naming.InvalidSnapNameError that identifies
+// the rule that was broken if it cannot.
+func ValidateNameDetailed(name string) error {
+	return naming.ValidateSnapDetailed(name)
+}
+
+var nameSuggestionSeparators = regexp.MustCompile("[^a-z0-9]+")
+
+// SuggestName derives a valid snap name from the given input, e.g. "My Cool
+// App!" becomes "my-cool-app". It returns an error if nothing usable is left
+// of the input.
+func SuggestName(input string) (string, error) {
+	parts := strings.Fields(nameSuggestionSeparators.ReplaceAllString(strings.ToLower(input), " "))
+	isDigits := func(s string) bool { return strings.Trim(s, "0123456789") == "" }
+	for len(parts) > 0 && isDigits(parts[0]) {
+		parts = parts[1:]
+	}
+	for len(parts) > 0 && isDigits(parts[len(parts)-1]) {
+		parts = parts[:len(parts)-1]
+	}
+
+	name := strings.Join(parts, "-")
+	if len(name) > 40 {
+		name = strings.TrimRight(name[:40], "-")
+	}
+	if err := ValidateName(name); err != nil {
+		return "", fmt.Errorf("cannot suggest a snap name for %q", input)
+	}
+	return name, nil
+}
+
 // ValidatePlugName checks if a string can be used as a slot name.
 //
 // Slot names and plug names within one snap must have unique names.
@@ -73,8 +124,11 @@ func ValidateInterfaceName(name string) error {
 	return naming.ValidateInterface(name)
 }
 
+// MaxVersionLength is the maximum length of a snap version.
+const MaxVersionLength = 32
+
 // NB keep this in sync with snapcraft and the review tools :-)
-var isValidVersion = regexp.MustCompile("^[a-zA-Z0-9](?:[a-zA-Z0-9:.+~-]{0,30}[a-zA-Z0-9+~])?$").MatchString
+var isValidVersion = regexp.MustCompile(fmt.Sprintf("^[a-zA-Z0-9](?:[a-zA-Z0-9:.+~-]{0,%d}[a-zA-Z0-9+~])?$", MaxVersionLength-2)).MatchString
 
 var isNonGraphicalASCII = regexp.MustCompile("[^[:graph:]]").MatchString
 var isInvalidFirstVersionChar = regexp.MustCompile("^[^a-zA-Z0-9]").MatchString
@@ -102,8 +156,8 @@ func ValidateVersion(version string) error {
 		// now we know it's a non-empty ASCII string, we can get serious
 		var reasons []string
 		// ... too long?
-		if len(version) > 32 {
-			reasons = append(reasons, fmt.Sprintf("cannot be longer than 32 characters (got: %d)", len(version)))
+		if len(version) > MaxVersionLength {
+			reasons = append(reasons, fmt.Sprintf("cannot be longer than %d characters (got: %d)", MaxVersionLength, len(version)))
 		}
 		// started with a symbol?
 		if isInvalidFirstVersionChar(version) {
@@ -135,14 +189,166 @@ func ValidateVersion(version string) error {
 	return nil
 }
 
-// ValidateLicense checks if a string is a valid SPDX expression.
+// VersionOptions tweaks the checks done by ValidateVersionWith.
+type VersionOptions struct {
+	// Semantic requires the version to be of the major.minor.patch
+	// form, optionally followed by a pre-release ("-rc1") and/or
+	// build ("+git123") suffix.
+	Semantic bool
+
+	// NoColons rejects versions with a ':', which are allowed by
+	// default but are easily mistaken for Debian-style epochs.
+	NoColons bool
+}
+
+// ValidateVersionWith checks if a string is a valid snap version, applying
+// the additional checks requested by the given options on top of the ones
+// done by ValidateVersion.
+func ValidateVersionWith(version string, opts VersionOptions) error {
+	if err := ValidateVersion(version); err != nil {
+		return err
+	}
+	if opts.NoColons && strings.Contains(version, ":") {
+		return fmt.Errorf("invalid snap version %q: colons are discouraged as they can be mistaken for an epoch", version)
+	}
+	if opts.Semantic {
+		if err := validateSemanticVersion(version); err != nil {
+			return fmt.Errorf("invalid snap version %q: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// ValidateVersionStrict checks if a string is a valid snap version that is
+// also a major.minor.patch semantic version.
+func ValidateVersionStrict(version string) error {
+	return ValidateVersionWith(version, VersionOptions{Semantic: true})
+}
+
+func validateSemanticVersion(version string) error {
+	// drop the build and pre-release suffixes, they are free-form
+	if idx := strings.IndexAny(version, "+-"); idx >= 0 {
+		version = version[:idx]
+	}
+	comps := strings.Split(version, ".")
+	if len(comps) != 3 {
+		return fmt.Errorf("must be of the form major.minor.patch (got %d components)", len(comps))
+	}
+	for i, name := range []string{"major", "minor", "patch"} {
+		comp := comps[i]
+		if _, err := strconv.ParseUint(comp, 10, 64); err != nil {
+			return fmt.Errorf("%s component %q is not a number", name, comp)
+		}
+		if len(comp) > 1 && comp[0] == '0' {
+			return fmt.Errorf("%s component %q cannot have leading zeros", name, comp)
+		}
+	}
+	return nil
+}
+
+// maxProvenanceLength is the maximum length of a provenance.
+const maxProvenanceLength = 255
+
+// validProvenance matches provenances made of alphanumerics separated
+// by single dashes.
+var validProvenance = regexp.MustCompile(`^[a-zA-Z0-9](?:-?[a-zA-Z0-9])*$`)
+
+// ValidateProvenance checks the provenance declared by the snap, if
+// any, an unset one stands for DefaultProvenance.
+func ValidateProvenance(info *Info) error {
+	provenance := info.SnapProvenance
+	if provenance == "" {
+		return nil
+	}
+	if len(provenance) > maxProvenanceLength {
+		return fmt.Errorf("invalid provenance: cannot be longer than %d characters (got: %d)", maxProvenanceLength, len(provenance))
+	}
+	if !validProvenance.MatchString(provenance) {
+		return fmt.Errorf("invalid provenance %q: must be made of ASCII letters and digits separated by single dashes", provenance)
+	}
+	return nil
+}
+
+// ValidateLicense checks if a string is a valid SPDX expression. The
+// error points to where in the expression the problem was found.
 func ValidateLicense(license string) error {
 	if err := spdx.ValidateLicense(license); err != nil {
+		if licErr, ok := err.(*spdx.LicenseError); ok {
+			if licErr.Token == "" {
+				return fmt.Errorf("cannot validate license %q: %s (at end of expression)", license, err)
+			}
+			return fmt.Errorf("cannot validate license %q: %s (at %q, offset %d)", license, err, licErr.Token, licErr.Offset)
+		}
 		return fmt.Errorf("cannot validate license %q: %s", license, err)
 	}
 	return nil
 }
 
+// ValidateLicenses checks that each of the given strings is a valid SPDX
+// expression, as needed by snaps combining components that each carry
+// their own license.
+func ValidateLicenses(licenses []string) error {
+	for i, license := range licenses {
+		if err := ValidateLicense(license); err != nil {
+			return fmt.Errorf("invalid license at index %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validLinksKey matches the kinds of links, e.g. "website" or "source-code".
+var validLinksKey = regexp.MustCompile(`^[a-z](?:-?[a-z0-9])*$`)
+
+// ValidateLinks checks that the links of the snap are URLs that can be
+// shown to users, that is http or https ones, or mailto ones for contacts.
+func ValidateLinks(info *Info) error {
+	keys := make([]string, 0, len(info.Links))
+	for key := range info.Links {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !validLinksKey.MatchString(key) {
+			return fmt.Errorf("invalid links key %q", key)
+		}
+		for _, link := range info.Links[key] {
+			if err := validateLink(key, link); err != nil {
+				return fmt.Errorf("invalid %q link %q: %v", key, link, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateLink(key, link string) error {
+	if link == "" {
+		return errors.New("cannot be empty")
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return errors.New("not a valid URL")
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return errors.New("missing host")
+		}
+	case "mailto":
+		if key != "contact" {
+			return errors.New(`"mailto" is only allowed for contact links`)
+		}
+		if u.Opaque == "" {
+			return errors.New("missing address")
+		}
+	case "":
+		return errors.New("missing scheme")
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
 // ValidateHook validates the content of the given HookInfo
 func ValidateHook(hook *HookInfo) error {
 	if err := naming.ValidateHook(hook.Name); err != nil {
@@ -150,15 +356,57 @@ func ValidateHook(hook *HookInfo) error {
 	}
 
 	// Also validate the command chain
+	seen := make(map[string]bool, len(hook.CommandChain))
 	for _, value := range hook.CommandChain {
+		if value == "" {
+			return errors.New("hook command-chain entries cannot be empty")
+		}
 		if !commandChainContentWhitelist.MatchString(value) {
 			return fmt.Errorf("hook command-chain contains illegal %q (legal: '%s')", value, commandChainContentWhitelist)
 		}
+		if seen[value] {
+			return fmt.Errorf("hook command-chain contains %q more than once", value)
+		}
+		seen[value] = true
+	}
+
+	// plugs and slots used by the hook must be defined by the snap
+	if hook.Snap != nil {
+		return validatePlugSlotRefs(hook.Snap, fmt.Sprintf("hook %q", hook.Name), hook.Plugs, hook.Slots)
 	}
 
 	return nil
 }
 
+// validatePlugSlotRefs checks that the given plugs and slots of an app or
+// hook are defined by the snap. Plugs and slots that are only referenced
+// by name are defined implicitly when the snap.yaml is parsed, so these
+// are always found.
+func validatePlugSlotRefs(info *Info, who string, plugs map[string]*PlugInfo, slots map[string]*SlotInfo) error {
+	plugNames := make([]string, 0, len(plugs))
+	for plugName := range plugs {
+		plugNames = append(plugNames, plugName)
+	}
+	sort.Strings(plugNames)
+	for _, plugName := range plugNames {
+		if _, ok := info.Plugs[plugName]; !ok {
+			return fmt.Errorf("%s references undefined plug %q", who, plugName)
+		}
+	}
+
+	slotNames := make([]string, 0, len(slots))
+	for slotName := range slots {
+		slotNames = append(slotNames, slotName)
+	}
+	sort.Strings(slotNames)
+	for _, slotName := range slotNames {
+		if _, ok := info.Slots[slotName]; !ok {
+			return fmt.Errorf("%s references undefined slot %q", who, slotName)
+		}
+	}
+	return nil
+}
+
 // ValidateAlias checks if a string can be used as an alias name.
 func ValidateAlias(alias string) error {
 	return naming.ValidateAlias(alias)
@@ -200,12 +448,39 @@ func validateSocketAddrPath(socket *SocketInfo, fieldName string, path string) e
 		return fmt.Errorf("invalid %q: %q should be written as %q", fieldName, path, clean)
 	}
 
-	if !(strings.HasPrefix(path, "$SNAP_DATA/") || strings.HasPrefix(path, "$SNAP_COMMON/") || strings.HasPrefix(path, "$XDG_RUNTIME_DIR/")) {
-		return fmt.Errorf(
-			"invalid %q: must have a prefix of $SNAP_DATA, $SNAP_COMMON or $XDG_RUNTIME_DIR", fieldName)
+	scope := socket.App.DaemonScope
+	prefixes, ok := socketPathPrefixes[scope]
+	if !ok {
+		return fmt.Errorf("invalid %q: cannot determine valid prefixes for daemon scope %q", fieldName, scope)
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix+"/") {
+			return nil
+		}
 	}
 
-	return nil
+	desc := "daemon sockets"
+	if scope != "" {
+		desc = fmt.Sprintf("%s daemon sockets", scope)
+	}
+	if len(prefixes) == 1 {
+		return fmt.Errorf("invalid %q: %s must have a prefix of %s", fieldName, desc, prefixes[0])
+	}
+	return fmt.Errorf(
+		"invalid %q: %s must have a prefix of %s or %s", fieldName, desc,
+		strings.Join(prefixes[:len(prefixes)-1], ", "), prefixes[len(prefixes)-1])
+}
+
+// socketPathPrefixes lists the directories socket paths can be in, for
+// each daemon scope. Daemons declaring to be system ones cannot use the
+// runtime directory of a user and user daemons cannot use the system-wide
+// data directories, while daemons not declaring a scope keep accepting the
+// runtime directory of root, like they did before daemon-scope existed.
+// No socket units are generated for user daemons yet, see ValidateApp.
+var socketPathPrefixes = map[DaemonScope][]string{
+	"":           {"$SNAP_DATA", "$SNAP_COMMON", "$XDG_RUNTIME_DIR"},
+	SystemDaemon: {"$SNAP_DATA", "$SNAP_COMMON"},
+	UserDaemon:   {"$XDG_RUNTIME_DIR"},
 }
 
 func validateSocketAddrAbstract(socket *SocketInfo, fieldName string, path string) error {
@@ -231,11 +506,55 @@ func validateSocketAddrNet(socket *SocketInfo, fieldName string, address string)
 	return validateSocketAddrNetPort(socket, fieldName, address)
 }
 
+// ExtraSocketBindHosts lists hosts that sockets may bind to in addition to
+// the loopback and wildcard addresses. IPv6 addresses must be bracketed and
+// may carry a zone, e.g. "[fe80::1%eth0]".
+var ExtraSocketBindHosts []string
+
+var validIPv6Zone = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// parseBracketedHost parses an IPv6 literal of the form "[addr]" or
+// "[addr%zone]" and returns it in canonical form.
+func parseBracketedHost(host string) (string, error) {
+	if !strings.HasPrefix(host, "[") || !strings.HasSuffix(host, "]") {
+		return "", fmt.Errorf("missing brackets")
+	}
+	addr := host[1 : len(host)-1]
+	zone := ""
+	if i := strings.IndexRune(addr, '%'); i >= 0 {
+		addr, zone = addr[:i], addr[i+1:]
+		if !validIPv6Zone.MatchString(zone) {
+			return "", fmt.Errorf("invalid zone %q", zone)
+		}
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return "", fmt.Errorf("%q is not an IPv6 address", addr)
+	}
+	if zone != "" {
+		return "[" + ip.String() + "%" + zone + "]", nil
+	}
+	return "[" + ip.String() + "]", nil
+}
+
 func validateSocketAddrNetHost(socket *SocketInfo, fieldName string, address string) error {
-	validAddresses := []string{"127.0.0.1", "[::1]", "[::]"}
-	for _, valid := range validAddresses {
-		if address == valid {
-			return nil
+	validAddresses := append([]string{"127.0.0.1", "[::1]", "[::]"}, ExtraSocketBindHosts...)
+
+	if strings.HasPrefix(address, "[") || strings.HasSuffix(address, "]") {
+		canonical, err := parseBracketedHost(address)
+		if err != nil {
+			return fmt.Errorf("invalid %q address %q: %v", fieldName, address, err)
+		}
+		for _, valid := range validAddresses {
+			if validCanonical, err := parseBracketedHost(valid); err == nil && validCanonical == canonical {
+				return nil
+			}
+		}
+	} else {
+		for _, valid := range validAddresses {
+			if address == valid {
+				return nil
+			}
 		}
 	}
 
@@ -259,6 +578,15 @@ func validateDescription(descr string) error {
 	if count := utf8.RuneCountInString(descr); count > 4096 {
 		return fmt.Errorf("description can have up to 4096 codepoints, got %d", count)
 	}
+	// control characters other than newlines and tabs mess up the
+	// terminal when displaying the description
+	offset := 0
+	for _, r := range descr {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return fmt.Errorf("description cannot contain control character %U, found at codepoint %d", r, offset)
+		}
+		offset++
+	}
 	return nil
 }
 
@@ -266,92 +594,480 @@ func validateTitle(title string) error {
 	if count := utf8.RuneCountInString(title); count > 40 {
 		return fmt.Errorf("title can have up to 40 codepoints, got %d", count)
 	}
+	// the title is optional, but if set it must show something
+	if title != "" && strings.IndexFunc(title, isVisible) < 0 {
+		return fmt.Errorf("title must contain visible characters, got %q", title)
+	}
 	return nil
 }
 
-// Validate verifies the content in the info.
-func Validate(info *Info) error {
-	name := info.InstanceName()
-	if name == "" {
-		return errors.New("snap name cannot be empty")
+// isVisible tells whether the rune is a graphic character other than
+// whitespace, unlike e.g. spaces or zero-width format characters.
+func isVisible(r rune) bool {
+	return unicode.IsGraphic(r) && !unicode.IsSpace(r)
+}
+
+// Thresholds past which valid but suspiciously long fields are warned about.
+const (
+	descriptionWarnCodepoints = 3900
+	titleWarnCodepoints       = 36
+)
+
+// commonSystemPaths are not off-limits for layouts but replacing them
+// wholesale hides a lot of the base snap.
+var commonSystemPaths = []string{
+	"/bin", "/etc", "/lib", "/lib64", "/sbin",
+	"/usr", "/usr/bin", "/usr/lib", "/usr/sbin", "/usr/share",
+	"/var", "/var/lib",
+}
+
+// ValidateWithWarnings verifies the content in the info like Validate does,
+// and also returns warnings about content that is valid but likely not what
+// the publisher intended.
+func ValidateWithWarnings(info *Info) (warnings []string, err error) {
+	return validateWarnings(info), Validate(info)
+}
+
+func validateWarnings(info *Info) []string {
+	var warnings []string
+
+	if count := utf8.RuneCountInString(info.Title()); count > titleWarnCodepoints && count <= 40 {
+		warnings = append(warnings, fmt.Sprintf("title is close to the limit of 40 codepoints, got %d", count))
+	}
+	if count := utf8.RuneCountInString(info.Description()); count > descriptionWarnCodepoints && count <= 4096 {
+		warnings = append(warnings, fmt.Sprintf("description is close to the limit of 4096 codepoints, got %d", count))
 	}
 
-	if err := ValidateName(info.SnapName()); err != nil {
-		return err
+	paths := make([]string, 0, len(info.Layout))
+	for path := range info.Layout {
+		paths = append(paths, path)
 	}
-	if err := ValidateInstanceName(name); err != nil {
-		return err
+	sort.Strings(paths)
+	for _, path := range paths {
+		mountPoint := info.ExpandSnapVariables(path)
+		if strutil.ListContains(commonSystemPaths, mountPoint) {
+			warnings = append(warnings, fmt.Sprintf("layout %q shadows the entire %s directory of the base snap", path, mountPoint))
+		}
 	}
 
-	if err := validateTitle(info.Title()); err != nil {
-		return err
+	for _, flag := range info.Assumes {
+		// snapd version requirements and malformed entries are left
+		// to ValidateAssumes
+		if assumesSnapdPrefix.MatchString(flag) || !validAssumesFeature.MatchString(flag) {
+			continue
+		}
+		if !SupportedAssumesFeatures[flag] {
+			warnings = append(warnings, fmt.Sprintf("assumes entry %q is not a feature known to this version of snapd", flag))
+		}
 	}
 
-	if err := validateDescription(info.Description()); err != nil {
-		return err
+	switch typ := info.GetType(); typ {
+	case TypeOS, TypeBase, TypeKernel:
+		// most other snaps depend on these, bumping their epoch can
+		// break a lot of them at once
+		if !info.Epoch.IsZero() {
+			warnings = append(warnings, fmt.Sprintf("%q snap %q declares epoch %s, snaps depending on it may break", typ, info.InstanceName(), info.Epoch))
+		}
+	}
+	if typ := info.GetType(); typ == TypeKernel || typ == TypeGadget {
+		if svcs := info.Services(); len(svcs) > 0 {
+			names := make([]string, 0, len(svcs))
+			for _, app := range svcs {
+				names = append(names, app.Name)
+			}
+			sort.Strings(names)
+			warnings = append(warnings, fmt.Sprintf("%q snap %q declares services: %s", typ, info.InstanceName(), strings.Join(names, ", ")))
+		}
 	}
 
-	if err := ValidateVersion(info.Version); err != nil {
-		return err
+	for _, appName := range sortedAppNames(info) {
+		app := info.Apps[appName]
+		if !app.IsService() {
+			continue
+		}
+		for _, t := range []struct {
+			desc    string
+			timeout timeout.Timeout
+		}{
+			{"start-timeout", app.StartTimeout},
+			{"stop-timeout", app.StopTimeout},
+		} {
+			// zero means the systemd default
+			if t.timeout > 0 && t.timeout < MinimumTimeout {
+				warnings = append(warnings, fmt.Sprintf("application %q: %s %s is shorter than %s, the service may be killed before it gets a chance to run", app.Name, t.desc, t.timeout, MinimumTimeout))
+			}
+		}
 	}
 
-	if err := info.Epoch.Validate(); err != nil {
-		return err
+	return warnings
+}
+
+// Validate verifies the content in the info.
+func Validate(info *Info) error {
+	if errs := ValidateAll(info); len(errs) > 0 {
+		return errs[0]
 	}
+	return nil
+}
 
-	if license := info.License; license != "" {
-		if err := ValidateLicense(license); err != nil {
-			return err
+// ValidateAll verifies the content in the info like Validate does, but
+// instead of stopping at the first problem it returns all of them.
+func ValidateAll(info *Info) []error {
+	errs, _ := validateWithConfig(info, ValidationConfig{})
+	return errs
+}
+
+// ValidationCheck identifies a group of the checks done when validating
+// a snap, see ValidationConfig.
+type ValidationCheck string
+
+const (
+	CheckName            ValidationCheck = "name"
+	CheckTitle           ValidationCheck = "title"
+	CheckDescription     ValidationCheck = "description"
+	CheckVersion         ValidationCheck = "version"
+	CheckProvenance      ValidationCheck = "provenance"
+	CheckLinks           ValidationCheck = "links"
+	CheckEpoch           ValidationCheck = "epoch"
+	CheckLicense         ValidationCheck = "license"
+	CheckEnvironment     ValidationCheck = "environment"
+	CheckApps            ValidationCheck = "apps"
+	CheckAppConflicts    ValidationCheck = "app-conflicts"
+	CheckPlugSlotRefs    ValidationCheck = "plug-slot-refs"
+	CheckAliases         ValidationCheck = "aliases"
+	CheckHooks           ValidationCheck = "hooks"
+	CheckPlugsSlots      ValidationCheck = "plugs-slots"
+	CheckBase            ValidationCheck = "base"
+	CheckCommonIDs       ValidationCheck = "common-ids"
+	CheckSystemUsernames ValidationCheck = "system-usernames"
+	CheckLayouts         ValidationCheck = "layouts"
+	CheckAssumes         ValidationCheck = "assumes"
+	CheckExternal        ValidationCheck = "external"
+)
+
+// Severity tells how the problems found by a check are reported.
+type Severity int
+
+const (
+	// SeverityError reports problems as errors, this is the default.
+	SeverityError Severity = iota
+	// SeverityWarning reports problems as warnings.
+	SeverityWarning
+	// SeverityOff ignores problems.
+	SeverityOff
+)
+
+// ValidationConfig tunes how strict ValidateWithConfig is. The zero value
+// reports all the problems as errors, like ValidateAll does.
+type ValidationConfig struct {
+	// Severities overrides the severity of the given checks, checks
+	// that are not listed are errors.
+	Severities map[ValidationCheck]Severity
+}
+
+func (config ValidationConfig) severity(check ValidationCheck) Severity {
+	if severity, ok := config.Severities[check]; ok {
+		return severity
+	}
+	return SeverityError
+}
+
+// ValidateWithConfig verifies the content in the info like ValidateAll,
+// reporting the problems found with the severity set in the config. The
+// warnings include the ones returned by ValidateWithWarnings.
+func ValidateWithConfig(info *Info, config ValidationConfig) (errs []error, warnings []string) {
+	errs, warnings = validateWithConfig(info, config)
+	return errs, append(warnings, validateWarnings(info)...)
+}
+
+func validateWithConfig(info *Info, config ValidationConfig) (errs []error, warnings []string) {
+	// check reports the problem, if any, and tells whether there was
+	// none, whatever its severity
+	check := func(id ValidationCheck, err error) bool {
+		if err == nil {
+			return true
 		}
+		switch config.severity(id) {
+		case SeverityError:
+			errs = append(errs, err)
+		case SeverityWarning:
+			warnings = append(warnings, err.Error())
+		}
+		return false
+	}
+
+	name := info.InstanceName()
+	if name == "" {
+		check(CheckName, errors.New("snap name cannot be empty"))
+	} else if check(CheckName, ValidateName(info.SnapName())) {
+		check(CheckName, ValidateInstanceName(name))
 	}
 
+	check(CheckTitle, validateTitle(info.Title()))
+	check(CheckDescription, validateDescription(info.Description()))
+	check(CheckVersion, ValidateVersion(info.Version))
+	check(CheckProvenance, ValidateProvenance(info))
+	check(CheckLinks, ValidateLinks(info))
+	if check(CheckEpoch, info.Epoch.Validate()) {
+		check(CheckEpoch, validateEpochConsistency(info))
+	}
+
+	if license := info.License; license != "" {
+		check(CheckLicense, ValidateLicense(license))
+	}
+
+	check(CheckEnvironment, validateEnvironment(&info.Environment))
+
 	// validate app entries
-	for _, app := range info.Apps {
+	appNames := sortedAppNames(info)
+	appsOk := true
+	for _, appName := range appNames {
+		app := info.Apps[appName]
 		if err := ValidateApp(app); err != nil {
-			return fmt.Errorf("invalid definition of application %q: %v", app.Name, err)
+			appsOk = check(CheckApps, fmt.Errorf("invalid definition of application %q: %v", app.Name, err))
 		}
 	}
 
-	// validate apps ordering according to after/before
-	if err := validateAppOrderCycles(info.Services()); err != nil {
-		return err
+	// validate apps ordering according to after/before, this relies on
+	// the application references being valid
+	if appsOk {
+		check(CheckAppConflicts, validateAppOrderCycles(info.Services()))
+		check(CheckAppConflicts, validateSocketAddressConflicts(info))
+		check(CheckAppConflicts, validateDesktopCollisions(info))
+	}
+
+	// Ensure that plugs and slots used by apps are defined.
+	for _, appName := range appNames {
+		app := info.Apps[appName]
+		check(CheckPlugSlotRefs, validatePlugSlotRefs(info, fmt.Sprintf("application %q", app.Name), app.Plugs, app.Slots))
 	}
 
 	// validate aliases
-	for alias, app := range info.LegacyAliases {
+	aliases := make([]string, 0, len(info.LegacyAliases))
+	for alias := range info.LegacyAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
 		if err := naming.ValidateAlias(alias); err != nil {
-			return fmt.Errorf("cannot have %q as alias name for app %q - use only letters, digits, dash, underscore and dot characters", alias, app.Name)
+			check(CheckAliases, fmt.Errorf("cannot have %q as alias name for app %q - use only letters, digits, dash, underscore and dot characters", alias, info.LegacyAliases[alias].Name))
 		}
 	}
+	check(CheckAliases, validateAliasCollisions(info))
 
 	// validate hook entries
-	for _, hook := range info.Hooks {
-		if err := ValidateHook(hook); err != nil {
-			return err
-		}
+	for _, hookName := range sortedHookNames(info) {
+		check(CheckHooks, ValidateHook(info.Hooks[hookName]))
 	}
+	check(CheckHooks, validateAppHookNameCollisions(info))
 
 	// Ensure that plugs and slots have appropriate names and interface names.
-	if err := plugsSlotsInterfacesNames(info); err != nil {
-		return err
-	}
+	check(CheckPlugsSlots, plugsSlotsInterfacesNames(info))
+
+	// Ensure that plug and slot attributes can be serialized.
+	check(CheckPlugsSlots, plugsSlotsAttrs(info))
 
 	// Ensure that plug and slot have unique names.
-	if err := plugsSlotsUniqueNames(info); err != nil {
-		return err
-	}
+	check(CheckPlugsSlots, plugsSlotsUniqueNames(info))
 
 	// Ensure that base field is valid
-	if err := ValidateBase(info); err != nil {
-		return err
-	}
+	check(CheckBase, ValidateBase(info))
+	check(CheckBase, ValidateTypeConstraints(info))
 
 	// ensure that common-id(s) are unique
+	check(CheckCommonIDs, ValidateCommonIDs(info))
+
+	// layouts can only use the system usernames declared here
+	check(CheckSystemUsernames, ValidateSystemUsernames(info))
+	check(CheckLayouts, ValidateLayoutAll(info))
+	check(CheckAssumes, ValidateAssumes(info))
+
+	// Run the checks added from outside of snapd itself.
+	for _, validator := range validators {
+		check(CheckExternal, validator(info))
+	}
+
+	return errs, warnings
+}
+
+// ValidateApps validates only the apps of the snap, their ordering and
+// the uniqueness of their common-ids, and returns all the problems found.
+// Unlike ValidateAll it does not need the rest of the info to be valid,
+// e.g. while the snap.yaml is still being written.
+func ValidateApps(info *Info) []error {
+	var errs []error
+	appsOk := true
+	for _, appName := range sortedAppNames(info) {
+		app := info.Apps[appName]
+		if err := ValidateApp(app); err != nil {
+			errs = append(errs, fmt.Errorf("invalid definition of application %q: %v", app.Name, err))
+			appsOk = false
+		}
+	}
+	// the ordering checks rely on the application references being valid
+	if appsOk {
+		if err := validateAppOrderCycles(info.Services()); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if err := ValidateCommonIDs(info); err != nil {
-		return err
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ValidateYaml parses the given snap.yaml and validates the result like
+// ValidateAll does. Parsing problems are reported as a YamlParseError,
+// validation ones as a YamlValidationError.
+func ValidateYaml(yamlData []byte) error {
+	info, err := InfoFromSnapYaml(yamlData)
+	if err != nil {
+		return YamlParseError{Err: err}
+	}
+	if errs := ValidateAll(info); len(errs) > 0 {
+		return YamlValidationError{Errs: errs}
+	}
+	return nil
+}
+
+// validators are the extra checks added with AddValidator.
+var validators []func(*Info) error
+
+// AddValidator adds a check that Validate and ValidateAll run after the
+// built-in ones, e.g. rules of the store review tools. It is meant to be
+// called at init time.
+func AddValidator(validator func(*Info) error) {
+	validators = append(validators, validator)
+}
+
+// MockValidators replaces the checks added with AddValidator, use it
+// without arguments to run only the built-in checks.
+func MockValidators(mocked ...func(*Info) error) (restore func()) {
+	old := validators
+	validators = mocked
+	return func() { validators = old }
+}
+
+// validateAppHookNameCollisions checks that no app has the same name as a
+// hook, it is confusing which one is meant where both can be referred to
+// by name.
+func validateAppHookNameCollisions(info *Info) error {
+	for _, hookName := range sortedHookNames(info) {
+		if _, ok := info.Apps[hookName]; ok {
+			return fmt.Errorf("application %q and hook %q cannot have the same name", hookName, hookName)
+		}
+	}
+	return nil
+}
+
+// validateAliasCollisions checks that no alias is claimed by two apps and
+// that no alias shadows another app of the snap.
+func validateAliasCollisions(info *Info) error {
+	owners := make(map[string]string, len(info.LegacyAliases))
+	claim := func(alias, appName string) error {
+		if other, ok := owners[alias]; ok && other != appName {
+			return fmt.Errorf("cannot set %q as alias for both %q and %q", alias, other, appName)
+		}
+		owners[alias] = appName
+		// legacy aliases commonly repeat the name of their own app
+		if _, ok := info.Apps[alias]; ok && alias != appName {
+			return fmt.Errorf("cannot set %q as alias for %q, it is the name of app %q", alias, appName, alias)
+		}
+		return nil
+	}
+
+	for _, appName := range sortedAppNames(info) {
+		for _, alias := range info.Apps[appName].LegacyAliases {
+			if err := claim(alias, appName); err != nil {
+				return err
+			}
+		}
+	}
+	aliases := make([]string, 0, len(info.LegacyAliases))
+	for alias := range info.LegacyAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if err := claim(alias, info.LegacyAliases[alias].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateAliasesAgainst checks the aliases declared by the snap against
+// the commands and aliases already known, e.g. those of the installed snaps.
+// The taken map goes from a command or alias name to the app it runs, as
+// "snap.app" (or just "snap" for the app named like its snap). Names taken
+// by apps of the snap itself are not a problem.
+func ValidateAliasesAgainst(info *Info, taken map[string]string) []error {
+	aliases := make([]string, 0, len(info.LegacyAliases))
+	for alias := range info.LegacyAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	var errs []error
+	for _, alias := range aliases {
+		target, ok := taken[alias]
+		if !ok {
+			continue
+		}
+		if snapName, _ := SplitSnapApp(target); snapName == info.InstanceName() {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("cannot use %q as alias for app %q, it is already used for %q", alias, info.LegacyAliases[alias].Name, target))
+	}
+	return errs
+}
+
+func layoutKindName(kind string) string {
+	if kind == "dir" {
+		return "directory"
+	}
+	return kind
+}
+
+// validateEpochConsistency checks that the snap can read the data of every
+// epoch it writes, the ordering of the lists is checked by Epoch.Validate.
+func validateEpochConsistency(info *Info) error {
+	if info.Epoch.IsZero() {
+		return nil
 	}
+	for _, w := range info.Epoch.Write {
+		if !intersect(info.Epoch.Read, []uint32{w}) {
+			return fmt.Errorf("epoch cannot write %d without reading it (read: %s, write: %s)",
+				w, formatEpochList(info.Epoch.Read), formatEpochList(info.Epoch.Write))
+		}
+	}
+	return nil
+}
 
-	return ValidateLayoutAll(info)
+func formatEpochList(l []uint32) string {
+	strs := make([]string, len(l))
+	for i, v := range l {
+		strs[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+func sortedAppNames(info *Info) []string {
+	names := make([]string, 0, len(info.Apps))
+	for name := range info.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedHookNames(info *Info) []string {
+	names := make([]string, 0, len(info.Hooks))
+	for name := range info.Hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // ValidateBase validates the base field.
@@ -362,115 +1078,663 @@ func ValidateBase(info *Info) error {
 			return fmt.Errorf(`cannot have "base" field on %q snap %q`, info.GetType(), info.InstanceName())
 		}
 	}
-
-	if info.Base == "none" && (len(info.Hooks) > 0 || len(info.Apps) > 0) {
-		return fmt.Errorf(`cannot have apps or hooks with base "none"`)
+
+	if info.Base == "none" && (len(info.Hooks) > 0 || len(info.Apps) > 0) {
+		return fmt.Errorf(`cannot have apps or hooks with base "none"`)
+	}
+	// layouts are set up in the mount namespace of apps and hooks
+	if info.Base == "none" && len(info.Layout) > 0 {
+		return fmt.Errorf(`cannot have layouts with base "none"`)
+	}
+
+	if info.Base != "" {
+		baseSnapName, instanceKey := SplitInstanceName(info.Base)
+		if instanceKey != "" {
+			return fmt.Errorf("base cannot specify a snap instance name: %q", info.Base)
+		}
+		if err := ValidateName(baseSnapName); err != nil {
+			return fmt.Errorf("invalid base name: %s", err)
+		}
+		// bases cannot have a base themselves, so this is the only
+		// possible cycle
+		if baseSnapName == info.SnapName() {
+			return fmt.Errorf("snap %q cannot use itself as base", info.SnapName())
+		}
+	}
+	return nil
+}
+
+// ValidateTypeConstraints checks that the base of kernel snaps makes sense
+// for their type, they are not run on top of a base so they cannot declare
+// one. Gadget snaps can use any base. Service apps in kernel and gadget
+// snaps are allowed but are usually a mistake, so they are only warned
+// about, see ValidateWithWarnings.
+func ValidateTypeConstraints(info *Info) error {
+	typ := info.GetType()
+	if typ != TypeKernel {
+		return nil
+	}
+
+	if info.Base != "" && info.Base != "none" {
+		return fmt.Errorf(`cannot have "base" field set to %q on %q snap %q`, info.Base, typ, info.InstanceName())
+	}
+	return nil
+}
+
+var (
+	validAssumesFeature = regexp.MustCompile(`^[a-z][a-z0-9]*(?:-[a-z0-9]+)*$`)
+	validAssumesSnapd   = regexp.MustCompile(`^snapd[1-9][0-9]*(?:\.[0-9]+(?:\.[0-9]+)?)?$`)
+	// feature names can start with snapd too, e.g. snapd-foo
+	assumesSnapdPrefix = regexp.MustCompile(`^snapd(?:[^a-z-]|$)`)
+)
+
+// SupportedAssumesFeatures contains the feature names that can be listed in
+// assumes entries and that this version of snapd actually provides.
+var SupportedAssumesFeatures = map[string]bool{
+	// Support for common data directory across revisions of a snap.
+	"common-data-dir": true,
+	// Support for the "Environment:" feature in snap.yaml
+	"snap-env": true,
+	// Support for the "command-chain" feature for apps and hooks in snap.yaml
+	"command-chain": true,
+}
+
+// ValidateAssumes checks that the assumes entries of the snap are either
+// snapd version requirements of the form snapdX[.Y[.Z]] or feature names.
+// Whether the features are actually provided is only known when the snap
+// is installed, unknown feature names are only warned about, see
+// ValidateWithWarnings.
+func ValidateAssumes(info *Info) error {
+	for _, flag := range info.Assumes {
+		if assumesSnapdPrefix.MatchString(flag) {
+			if !validAssumesSnapd.MatchString(flag) {
+				return fmt.Errorf(`invalid assumes entry %q: snapd version requirements must be of the form snapdX[.Y[.Z]], e.g. "snapd2.45"`, flag)
+			}
+			continue
+		}
+		if !validAssumesFeature.MatchString(flag) {
+			return fmt.Errorf(`invalid assumes entry %q: must be a feature name made of lowercase letters, digits and dashes, or a snapd version requirement like "snapd2.45"`, flag)
+		}
+	}
+	return nil
+}
+
+// MaxLayouts is the maximum number of layouts a snap can declare, each one
+// is a mount set up in the mount namespace of the snap.
+var MaxLayouts = 1000
+
+// MaxLayoutDepth is the maximum number of components of the mount point of
+// a layout, once snap variables are expanded.
+var MaxLayoutDepth = 32
+
+// ValidateLayoutAll validates the consistency of all the layout elements in a snap.
+func ValidateLayoutAll(info *Info) error {
+	if len(info.Layout) > MaxLayouts {
+		return fmt.Errorf("snap has %d layouts, at most %d are allowed", len(info.Layout), MaxLayouts)
+	}
+
+	paths := make([]string, 0, len(info.Layout))
+	for _, layout := range info.Layout {
+		paths = append(paths, layout.Path)
+	}
+	sort.Strings(paths)
+
+	// Expand the mount points once, they are needed by most checks below.
+	mountPoints := make(map[string]string, len(paths))
+	for _, path := range paths {
+		mountPoints[path] = info.ExpandSnapVariables(path)
+	}
+
+	// Validate that mount points are not nested too deeply.
+	for _, path := range paths {
+		mountPoint := filepath.Clean(mountPoints[path])
+		if depth := strings.Count(mountPoint, "/"); depth > MaxLayoutDepth {
+			return fmt.Errorf("layout %q is nested too deeply (%d levels, max %d)", path, depth, MaxLayoutDepth)
+		}
+	}
+
+	// Validate that each source path is used consistently as a file or as a directory.
+	sourceKindMap := make(map[string]string)
+	for _, path := range paths {
+		layout := info.Layout[path]
+		if layout.Bind != "" {
+			// Layout refers to a directory.
+			sourcePath := info.ExpandSnapVariables(layout.Bind)
+			if kind, ok := sourceKindMap[sourcePath]; ok {
+				if kind != "dir" {
+					return fmt.Errorf("layout %q refers to directory %q but another layout treats it as file", layout.Path, layout.Bind)
+				}
+			}
+			sourceKindMap[sourcePath] = "dir"
+		}
+		if layout.BindFile != "" {
+			// Layout refers to a file.
+			sourcePath := info.ExpandSnapVariables(layout.BindFile)
+			if kind, ok := sourceKindMap[sourcePath]; ok {
+				if kind != "file" {
+					return fmt.Errorf("layout %q refers to file %q but another layout treats it as a directory", layout.Path, layout.BindFile)
+				}
+			}
+			sourceKindMap[sourcePath] = "file"
+		}
+	}
+
+	// Validate that each mount point is used consistently as a file or as a
+	// directory, both by other layouts and as a bind mount source.
+	type mountPointUse struct {
+		kind   string
+		layout string
+	}
+	mountPointMap := make(map[string]mountPointUse)
+	for _, path := range paths {
+		layout := info.Layout[path]
+		if layout.Symlink != "" {
+			// Symlinks can point to either kind.
+			continue
+		}
+		use := mountPointUse{kind: "dir", layout: layout.Path}
+		if layout.BindFile != "" {
+			use.kind = "file"
+		}
+		mountPoint := mountPoints[path]
+		if other, ok := mountPointMap[mountPoint]; ok && other.kind != use.kind {
+			return fmt.Errorf("layout %q uses %q as a %s but layout %q uses it as a %s", layout.Path, mountPoint, layoutKindName(use.kind), other.layout, layoutKindName(other.kind))
+		}
+		mountPointMap[mountPoint] = use
+	}
+	for _, path := range paths {
+		layout := info.Layout[path]
+		source, kind := layout.Bind, "dir"
+		if layout.BindFile != "" {
+			source, kind = layout.BindFile, "file"
+		}
+		if source == "" {
+			continue
+		}
+		sourcePath := info.ExpandSnapVariables(source)
+		if other, ok := mountPointMap[sourcePath]; ok && other.kind != kind {
+			return fmt.Errorf("layout %q uses %q as a %s but layout %q uses it as a %s", layout.Path, source, layoutKindName(kind), other.layout, layoutKindName(other.kind))
+		}
+	}
+
+	// Validate each layout item and collect resulting constraints.
+	constraints := make([]LayoutConstraint, 0, len(info.Layout))
+	for _, path := range paths {
+		layout := info.Layout[path]
+		if err := ValidateLayout(layout, constraints); err != nil {
+			return err
+		}
+		constraints = append(constraints, layout.constraint())
+	}
+
+	// Validate that symlinks do not point to where other symlinks are
+	// created, following them can end up going around in circles.
+	symlinkMountPoints := make(map[string]string)
+	for _, path := range paths {
+		if info.Layout[path].Symlink != "" {
+			symlinkMountPoints[filepath.Clean(mountPoints[path])] = path
+		}
+	}
+	for _, path := range paths {
+		layout := info.Layout[path]
+		if layout.Symlink == "" {
+			continue
+		}
+		target := filepath.Clean(info.ExpandSnapVariables(layout.Symlink))
+		if other, ok := symlinkMountPoints[target]; ok {
+			return fmt.Errorf("layout %q is a symlink to symlink layout %q and may create a loop", layout.Path, other)
+		}
+	}
+
+	// The constraints above depend on the order of the layouts, once
+	// variables are expanded a tmpfs can still end up above a later item.
+	for _, path := range paths {
+		tmpfs := info.Layout[path]
+		if tmpfs.Type != "tmpfs" {
+			continue
+		}
+		tree := mountedTree(mountPoints[path])
+		for _, otherPath := range paths {
+			other := info.Layout[otherPath]
+			if other.Type != "" {
+				continue
+			}
+			mountPoint := mountPoints[otherPath]
+			if mountPoint != string(tree) && tree.IsOffLimits(mountPoint) {
+				return fmt.Errorf("layout %q is shadowed by tmpfs layout %q", other.Path, tmpfs.Path)
+			}
+		}
+	}
+	return nil
+}
+
+func plugsSlotsInterfacesNames(info *Info) error {
+	for plugName, plug := range info.Plugs {
+		if err := ValidatePlugName(plugName); err != nil {
+			return err
+		}
+		if err := ValidateInterfaceName(plug.Interface); err != nil {
+			return fmt.Errorf("invalid interface name %q for plug %q", plug.Interface, plugName)
+		}
+	}
+	for slotName, slot := range info.Slots {
+		if err := ValidateSlotName(slotName); err != nil {
+			return err
+		}
+		if err := ValidateInterfaceName(slot.Interface); err != nil {
+			return fmt.Errorf("invalid interface name %q for slot %q", slot.Interface, slotName)
+		}
+	}
+	return nil
+}
+
+const (
+	// maxAttrDepth is how deeply plug and slot attribute values can
+	// be nested
+	maxAttrDepth = 16
+	// maxAttrsSize is the maximum size of the JSON encoding of the
+	// attributes of a plug or slot
+	maxAttrsSize = 64 * 1024
+)
+
+// validateAttrValue checks that an attribute value is made only of
+// types that can be serialized, and that it is not nested too deeply.
+func validateAttrValue(path string, value interface{}, depth int) error {
+	if depth > maxAttrDepth {
+		return fmt.Errorf("%q: nested too deeply (max %d levels)", path, maxAttrDepth)
+	}
+	switch v := value.(type) {
+	case nil, string, bool, int, int64, float64:
+		// scalars are fine
+	case []interface{}:
+		for i, elem := range v {
+			if err := validateAttrValue(fmt.Sprintf("%s[%d]", path, i), elem, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := validateAttrValue(path+"."+key, v[key], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%q: unsupported type %T", path, value)
+	}
+	return nil
+}
+
+// validateAttrs checks the attributes of the plug or slot described by
+// who.
+func validateAttrs(who string, attrs map[string]interface{}) error {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := validateAttrValue(key, attrs[key], 1); err != nil {
+			return fmt.Errorf("%s has invalid attribute %v", who, err)
+		}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("%s has invalid attributes: %v", who, err)
+	}
+	if len(data) > maxAttrsSize {
+		return fmt.Errorf("%s attributes are too large (%d bytes, max %d)", who, len(data), maxAttrsSize)
+	}
+	return nil
+}
+
+func plugsSlotsAttrs(info *Info) error {
+	plugNames := make([]string, 0, len(info.Plugs))
+	for plugName := range info.Plugs {
+		plugNames = append(plugNames, plugName)
+	}
+	sort.Strings(plugNames)
+	for _, plugName := range plugNames {
+		if err := validateAttrs(fmt.Sprintf("plug %q", plugName), info.Plugs[plugName].Attrs); err != nil {
+			return err
+		}
+	}
+	slotNames := make([]string, 0, len(info.Slots))
+	for slotName := range info.Slots {
+		slotNames = append(slotNames, slotName)
+	}
+	sort.Strings(slotNames)
+	for _, slotName := range slotNames {
+		if err := validateAttrs(fmt.Sprintf("slot %q", slotName), info.Slots[slotName].Attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func plugsSlotsUniqueNames(info *Info) error {
+	// look up the names of the smaller collection in the bigger one
+	if len(info.Slots) < len(info.Plugs) {
+		for slotName := range info.Slots {
+			if info.Plugs[slotName] != nil {
+				return fmt.Errorf("cannot have plug and slot with the same name: %q", slotName)
+			}
+		}
+		return nil
+	}
+	for plugName := range info.Plugs {
+		if info.Slots[plugName] != nil {
+			return fmt.Errorf("cannot have plug and slot with the same name: %q", plugName)
+		}
+	}
+	return nil
+}
+
+func validateField(name, cont string, whitelist *regexp.Regexp) error {
+	if !whitelist.MatchString(cont) {
+		return fmt.Errorf("app description field '%s' contains illegal %q (legal: '%s')", name, cont, whitelist)
+
+	}
+	return nil
+}
+
+// danglingCommandArgVar matches a "$" that doesn't start a variable name.
+var danglingCommandArgVar = regexp.MustCompile(`\$([^A-Za-z_]|$)`)
+
+// validateCommandArgs checks that a command passing the whitelist is also
+// run the way it reads. snap-exec splits the command on spaces, runs the
+// first part relative to the snap and expands $VAR references in the rest,
+// so anything else a shell would do with the string does not happen.
+func validateCommandArgs(name, cmd string) error {
+	if cmd == "" {
+		return nil
+	}
+	const hint = "use a command-chain wrapper script for anything else"
+	parts := strings.Split(cmd, " ")
+	if parts[0] == "" {
+		return fmt.Errorf("app description field '%s' %q cannot start with a space", name, cmd)
+	}
+	if strings.ContainsRune(parts[0], '$') {
+		return fmt.Errorf("app description field '%s' %q cannot use variables in the executable, they are only expanded in arguments (%s)", name, cmd, hint)
+	}
+	for _, arg := range parts[1:] {
+		if danglingCommandArgVar.MatchString(arg) {
+			return fmt.Errorf("app description field '%s' %q has argument %q with a \"$\" that does not start a variable name (%s)", name, cmd, arg, hint)
+		}
+	}
+	return nil
+}
+
+func validateAppSocket(socket *SocketInfo) error {
+	if err := validateSocketName(socket.Name); err != nil {
+		return err
+	}
+
+	if err := validateSocketMode(socket.SocketMode); err != nil {
+		return err
+	}
+
+	// a socket needs at least one of listen-stream or listen-datagram,
+	// report the missing listen-stream when neither is given
+	if socket.ListenDatagram != "" {
+		if err := validateSocketAddr(socket, "listen-datagram", socket.ListenDatagram); err != nil {
+			return err
+		}
+	}
+	if socket.ListenDatagram == "" || socket.ListenStream != "" {
+		if err := validateSocketAddr(socket, "listen-stream", socket.ListenStream); err != nil {
+			return err
+		}
 	}
 
-	if info.Base != "" {
-		baseSnapName, instanceKey := SplitInstanceName(info.Base)
-		if instanceKey != "" {
-			return fmt.Errorf("base cannot specify a snap instance name: %q", info.Base)
+	return validateSocketModeUse(socket)
+}
+
+// validateSocketModeUse checks that socket-mode is only set for sockets
+// bound to a path, the permissions of abstract and net sockets cannot be
+// set.
+func validateSocketModeUse(socket *SocketInfo) error {
+	if socket.SocketMode == 0 {
+		return nil
+	}
+	for _, addr := range []struct{ field, address string }{
+		{"listen-stream", socket.ListenStream},
+		{"listen-datagram", socket.ListenDatagram},
+	} {
+		if addr.address == "" {
+			continue
 		}
-		if err := ValidateName(baseSnapName); err != nil {
-			return fmt.Errorf("invalid base name: %s", err)
+		if addr.address[0] != '/' && addr.address[0] != '$' {
+			return fmt.Errorf("cannot use socket-mode with %q %q, it only applies to sockets bound to a path", addr.field, addr.address)
 		}
 	}
 	return nil
 }
 
-// ValidateLayoutAll validates the consistency of all the layout elements in a snap.
-func ValidateLayoutAll(info *Info) error {
-	paths := make([]string, 0, len(info.Layout))
-	for _, layout := range info.Layout {
-		paths = append(paths, layout.Path)
+// socketPathScope returns the daemon scope the path of a socket of app
+// belongs to, going by its prefix, or "" if it is not a path. The data
+// directories are system-wide while the runtime directory is the one of
+// the user running the service, root for system daemons.
+func socketPathScope(app *AppInfo, address string) DaemonScope {
+	switch {
+	case strings.HasPrefix(address, "$SNAP_DATA/"), strings.HasPrefix(address, "$SNAP_COMMON/"):
+		return SystemDaemon
+	case strings.HasPrefix(address, "$XDG_RUNTIME_DIR/"):
+		if app.DaemonScope == "" {
+			return SystemDaemon
+		}
+		return app.DaemonScope
 	}
-	sort.Strings(paths)
+	return ""
+}
 
-	// Validate that each source path is used consistently as a file or as a directory.
-	sourceKindMap := make(map[string]string)
-	for _, path := range paths {
-		layout := info.Layout[path]
-		if layout.Bind != "" {
-			// Layout refers to a directory.
-			sourcePath := info.ExpandSnapVariables(layout.Bind)
-			if kind, ok := sourceKindMap[sourcePath]; ok {
-				if kind != "dir" {
-					return fmt.Errorf("layout %q refers to directory %q but another layout treats it as file", layout.Path, layout.Bind)
-				}
+// validateAppSocketScopes checks that the path sockets of the app are all
+// in the same scope, as they activate the same service.
+func validateAppSocketScopes(app *AppInfo) error {
+	socketNames := make([]string, 0, len(app.Sockets))
+	for name := range app.Sockets {
+		socketNames = append(socketNames, name)
+	}
+	sort.Strings(socketNames)
+
+	var firstName string
+	var firstScope DaemonScope
+	for _, name := range socketNames {
+		socket := app.Sockets[name]
+		for _, address := range []string{socket.ListenStream, socket.ListenDatagram} {
+			scope := socketPathScope(app, address)
+			if scope == "" {
+				continue
 			}
-			sourceKindMap[sourcePath] = "dir"
-		}
-		if layout.BindFile != "" {
-			// Layout refers to a file.
-			sourcePath := info.ExpandSnapVariables(layout.BindFile)
-			if kind, ok := sourceKindMap[sourcePath]; ok {
-				if kind != "file" {
-					return fmt.Errorf("layout %q refers to file %q but another layout treats it as a directory", layout.Path, layout.BindFile)
-				}
+			if firstScope == "" {
+				firstName, firstScope = name, scope
+				continue
+			}
+			if scope != firstScope {
+				return fmt.Errorf("socket %q uses %s scope but socket %q uses %s scope, all sockets of app %q must be in the same scope", firstName, firstScope, name, scope, app.Name)
 			}
-			sourceKindMap[sourcePath] = "file"
 		}
 	}
+	return nil
+}
 
-	// Validate each layout item and collect resulting constraints.
-	constraints := make([]LayoutConstraint, 0, len(info.Layout))
-	for _, path := range paths {
-		layout := info.Layout[path]
-		if err := ValidateLayout(layout, constraints); err != nil {
-			return err
-		}
-		constraints = append(constraints, layout.constraint())
+// socketAddress is the concrete address a socket listens on.
+type socketAddress struct {
+	// key identifies the address, sockets with the same key conflict
+	key string
+	// port identifies the protocol and port of net sockets
+	port string
+	// wildcard is set for net sockets listening on all the hosts, they
+	// conflict with any other socket on the same port
+	wildcard bool
+}
+
+// socketAddressFor returns the concrete address a socket listens on. Paths
+// are expanded like the generated socket units do, path and abstract
+// sockets share their namespace between stream and datagram sockets, net
+// sockets are keyed by protocol.
+func socketAddressFor(socket *SocketInfo, fieldName, address string) socketAddress {
+	switch address[0] {
+	case '/', '$':
+		snapInfo := socket.App.Snap
+		path := strings.Replace(address, "$SNAP_DATA", snapInfo.DataDir(), -1)
+		path = strings.Replace(path, "$XDG_RUNTIME_DIR", snapInfo.UserXdgRuntimeDir(sys.UserID(0)), -1)
+		path = strings.Replace(path, "$SNAP_COMMON", snapInfo.CommonDataDir(), -1)
+		return socketAddress{key: "path:" + filepath.Clean(path)}
+	case '@':
+		return socketAddress{key: "abstract:" + address}
+	}
+
+	proto := "tcp"
+	if fieldName == "listen-datagram" {
+		proto = "udp"
+	}
+	// a bare port listens on all addresses
+	host, port := "[::]", address
+	if i := strings.LastIndex(address, ":"); i >= 0 {
+		host, port = address[:i], address[i+1:]
+	}
+	if canonical, err := parseBracketedHost(host); err == nil {
+		host = canonical
+	}
+	if val, err := strconv.ParseUint(port, 10, 16); err == nil {
+		port = strconv.FormatUint(val, 10)
+	}
+	return socketAddress{
+		key:      proto + ":" + host + ":" + port,
+		port:     proto + ":" + port,
+		wildcard: host == "[::]" || host == "0.0.0.0",
 	}
-	return nil
 }
 
-func plugsSlotsInterfacesNames(info *Info) error {
-	for plugName, plug := range info.Plugs {
-		if err := ValidatePlugName(plugName); err != nil {
-			return err
+// validateSocketAddressConflicts checks that no two sockets of the snap
+// listen on the same address.
+func validateSocketAddressConflicts(info *Info) error {
+	type portUser struct {
+		name     string
+		wildcard bool
+	}
+	seen := make(map[string]string)
+	ports := make(map[string][]portUser)
+	for _, appName := range sortedAppNames(info) {
+		app := info.Apps[appName]
+		socketNames := make([]string, 0, len(app.Sockets))
+		for name := range app.Sockets {
+			socketNames = append(socketNames, name)
 		}
-		if err := ValidateInterfaceName(plug.Interface); err != nil {
-			return fmt.Errorf("invalid interface name %q for plug %q", plug.Interface, plugName)
+		sort.Strings(socketNames)
+
+		for _, socketName := range socketNames {
+			socket := app.Sockets[socketName]
+			fullName := app.Name + "." + socketName
+			for _, addr := range []struct{ field, address string }{
+				{"listen-stream", socket.ListenStream},
+				{"listen-datagram", socket.ListenDatagram},
+			} {
+				if addr.address == "" {
+					continue
+				}
+				sa := socketAddressFor(socket, addr.field, addr.address)
+				if other, ok := seen[sa.key]; ok {
+					return fmt.Errorf("sockets %q and %q cannot both listen on %q", other, fullName, addr.address)
+				}
+				seen[sa.key] = fullName
+				if sa.port == "" {
+					continue
+				}
+				for _, other := range ports[sa.port] {
+					if other.wildcard || sa.wildcard {
+						return fmt.Errorf("sockets %q and %q cannot both listen on %q", other.name, fullName, addr.address)
+					}
+				}
+				ports[sa.port] = append(ports[sa.port], portUser{fullName, sa.wildcard})
+			}
 		}
 	}
-	for slotName, slot := range info.Slots {
-		if err := ValidateSlotName(slotName); err != nil {
-			return err
+	return nil
+}
+
+// validateAppActivatesOn checks that the slots an app is activated on are
+// dbus slots of the same snap.
+func validateAppActivatesOn(app *AppInfo) error {
+	if len(app.ActivatesOn) == 0 {
+		return nil
+	}
+
+	for _, slot := range app.ActivatesOn {
+		if slot.Snap != app.Snap || app.Snap.Slots[slot.Name] != slot {
+			return fmt.Errorf("invalid activates-on value %q: slot is not defined by the snap", slot.Name)
 		}
-		if err := ValidateInterfaceName(slot.Interface); err != nil {
-			return fmt.Errorf("invalid interface name %q for slot %q", slot.Interface, slotName)
+		if slot.Interface != "dbus" {
+			return fmt.Errorf("invalid activates-on value %q: slot does not use the dbus interface", slot.Name)
 		}
 	}
 	return nil
 }
-func plugsSlotsUniqueNames(info *Info) error {
-	// we could choose the smaller collection if we wanted to optimize this check
-	for plugName := range info.Plugs {
-		if info.Slots[plugName] != nil {
-			return fmt.Errorf("cannot have plug and slot with the same name: %q", plugName)
+
+var validEnvironmentName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnvironment checks that environment variable names are usable in
+// the generated service and wrapper files and that values hold no NUL bytes.
+func validateEnvironment(env *strutil.OrderedMap) error {
+	for _, k := range env.Keys() {
+		if !validEnvironmentName.MatchString(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+		if strings.IndexByte(env.Get(k), 0) >= 0 {
+			return fmt.Errorf("environment variable %q cannot contain NUL bytes", k)
 		}
 	}
 	return nil
 }
 
-func validateField(name, cont string, whitelist *regexp.Regexp) error {
-	if !whitelist.MatchString(cont) {
-		return fmt.Errorf("app description field '%s' contains illegal %q (legal: '%s')", name, cont, whitelist)
+// validateAppEnvironment checks the environment declared for the app.
+func validateAppEnvironment(app *AppInfo) error {
+	return validateEnvironment(&app.Environment)
+}
 
+// validateAppDesktop checks that the desktop file referenced by the app
+// is a plain file name in meta/gui/ of the form <name>.desktop, so that
+// it gets installed as <snap>_<name>.desktop.
+func validateAppDesktop(app *AppInfo) error {
+	if app.Desktop == "" {
+		return nil
+	}
+	if strings.ContainsRune(app.Desktop, '/') {
+		return fmt.Errorf("invalid desktop file name %q: cannot contain path separators", app.Desktop)
+	}
+	name := strings.TrimSuffix(app.Desktop, ".desktop")
+	if name == app.Desktop {
+		return fmt.Errorf("invalid desktop file name %q: must end in .desktop", app.Desktop)
+	}
+	if !ValidAppName(name) {
+		return fmt.Errorf("invalid desktop file name %q: %q must be a valid app name", app.Desktop, name)
 	}
 	return nil
 }
 
-func validateAppSocket(socket *SocketInfo) error {
-	if err := validateSocketName(socket.Name); err != nil {
-		return err
-	}
-
-	if err := validateSocketMode(socket.SocketMode); err != nil {
-		return err
+// validateDesktopCollisions checks that no two apps reference the same
+// desktop file.
+func validateDesktopCollisions(info *Info) error {
+	owners := make(map[string]string)
+	for _, appName := range sortedAppNames(info) {
+		desktop := info.Apps[appName].Desktop
+		if desktop == "" {
+			continue
+		}
+		if other, ok := owners[desktop]; ok {
+			return fmt.Errorf("application %q desktop file %q must be unique, already used by application %q", appName, desktop, other)
+		}
+		owners[desktop] = appName
 	}
-	return validateSocketAddr(socket, "listen-stream", socket.ListenStream)
+	return nil
 }
 
 // validateAppOrderCycles checks for cycles in app ordering dependencies
@@ -481,6 +1745,10 @@ func validateAppOrderCycles(apps []*AppInfo) error {
 	return nil
 }
 
+// validateAppOrderNames checks that the before/after dependencies of the app
+// name services of the same snap. Any daemon counts as a service here,
+// including oneshot daemons, whether activated by a timer or not: they can
+// both request ordering and be ordered against.
 func validateAppOrderNames(app *AppInfo, dependencies []string) error {
 	// we must be a service to request ordering
 	if len(dependencies) > 0 && !app.IsService() {
@@ -488,9 +1756,18 @@ func validateAppOrderNames(app *AppInfo, dependencies []string) error {
 	}
 
 	for _, dep := range dependencies {
+		// the cycle detector would catch it too, but less clearly
+		if dep == app.Name {
+			return errors.New("before/after references the application itself")
+		}
 		// dependency is not defined
 		other, ok := app.Snap.Apps[dep]
 		if !ok {
+			// app names cannot contain dots or slashes, this looks
+			// like an attempt at naming an app of another snap
+			if strings.ContainsAny(dep, "./") {
+				return fmt.Errorf("before/after references %q, ordering against applications of other snaps is not supported", dep)
+			}
 			return fmt.Errorf("before/after references a missing application %q", dep)
 		}
 
@@ -501,6 +1778,11 @@ func validateAppOrderNames(app *AppInfo, dependencies []string) error {
 	return nil
 }
 
+// MinimumTimeout is the shortest start-timeout or stop-timeout a service
+// should declare, shorter ones get the service killed before it had a
+// chance to do anything. Set it to 0 to disable the warning.
+var MinimumTimeout = timeout.Timeout(time.Second)
+
 func validateAppTimeouts(app *AppInfo) error {
 	type T struct {
 		desc    string
@@ -533,15 +1815,41 @@ func validateAppTimer(app *AppInfo) error {
 		return errors.New("timer is only applicable to services")
 	}
 
-	if _, err := timeutil.ParseSchedule(app.Timer.Timer); err != nil {
+	// the timer activates the service, which must then run to completion
+	// or be a plain process for that to make sense
+	switch app.Daemon {
+	case "simple", "oneshot":
+		// fine
+	default:
+		return fmt.Errorf("timer %q cannot be used with %q daemons, only with simple or oneshot ones", app.Timer.Timer, app.Daemon)
+	}
+
+	return ValidateTimer(app.Timer.Timer)
+}
+
+// ValidateTimer checks that the schedule of a timer is valid.
+func ValidateTimer(schedule string) error {
+	if _, err := timeutil.ParseSchedule(schedule); err != nil {
 		return fmt.Errorf("timer has invalid format: %v", err)
 	}
+	return nil
+}
+
+// MaxRestartDelay is the longest restart-delay a service may declare.
+var MaxRestartDelay = timeout.Timeout(15 * time.Minute)
 
+// ValidateRestartCondition checks that the given string is one of the
+// supported restart conditions.
+func ValidateRestartCondition(cond string) error {
+	if _, ok := RestartMap[cond]; !ok {
+		return fmt.Errorf("invalid restart condition: %q", cond)
+	}
 	return nil
 }
 
 func validateAppRestart(app *AppInfo) error {
-	// app.RestartCond value is validated when unmarshalling
+	// app.RestartCond value is validated when unmarshalling, but
+	// AppInfo can also be built by hand
 
 	if app.RestartDelay == 0 && app.RestartCond == "" {
 		return nil
@@ -555,9 +1863,20 @@ func validateAppRestart(app *AppInfo) error {
 		if app.RestartDelay < 0 {
 			return errors.New("restart-delay cannot be negative")
 		}
+
+		if app.RestartDelay > MaxRestartDelay {
+			return fmt.Errorf("restart-delay cannot be longer than %s", MaxRestartDelay)
+		}
+
+		if app.RestartCond == RestartNever {
+			return errors.New(`restart-delay cannot be used with restart-condition "never"`)
+		}
 	}
 
 	if app.RestartCond != "" {
+		if err := ValidateRestartCondition(string(app.RestartCond)); err != nil {
+			return err
+		}
 		if !app.IsService() {
 			return errors.New("restart-condition is only applicable to services")
 		}
@@ -567,8 +1886,8 @@ func validateAppRestart(app *AppInfo) error {
 
 // appContentWhitelist is the whitelist of legal chars in the "apps"
 // section of snap.yaml. Do not allow any of [',",`] here or snap-exec
-// will get confused. chainContentWhitelist is the same, but for the
-// command-chain, which also doesn't allow whitespace.
+// will get confused. commandChainContentWhitelist is the same, but for
+// the command-chain and the completer, which also don't allow whitespace.
 var appContentWhitelist = regexp.MustCompile(`^[A-Za-z0-9/. _#:$-]*$`)
 var commandChainContentWhitelist = regexp.MustCompile(`^[A-Za-z0-9/._#:$-]*$`)
 
@@ -577,6 +1896,38 @@ func ValidAppName(n string) bool {
 	return naming.ValidateApp(n) == nil
 }
 
+// validBusName matches D-Bus well-known bus names, see
+// https://dbus.freedesktop.org/doc/dbus-specification.html#message-protocol-names-bus
+var validBusName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*(\.[a-zA-Z_][a-zA-Z0-9_-]*)+$`)
+
+// ValidateBusName checks if a string can be used as a D-Bus well-known
+// bus name.
+func ValidateBusName(busName string) error {
+	if len(busName) > 255 {
+		return fmt.Errorf("bus name %q is too long (must be <= 255)", busName)
+	}
+	if !validBusName.MatchString(busName) {
+		return fmt.Errorf("invalid bus name: %q", busName)
+	}
+	return nil
+}
+
+// validateCompleter checks that the completer is a path to a file
+// inside the snap, anything else would be silently ignored.
+func validateCompleter(completer string) error {
+	if err := validateField("completer", completer, commandChainContentWhitelist); err != nil {
+		return err
+	}
+	if strings.HasPrefix(completer, "/") {
+		return fmt.Errorf("completer %q must be a path relative to the snap", completer)
+	}
+	clean := filepath.Clean(completer)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("completer %q must point to a file inside the snap", completer)
+	}
+	return nil
+}
+
 // ValidateApp verifies the content in the app info.
 func ValidateApp(app *AppInfo) error {
 	switch app.Daemon {
@@ -586,6 +1937,13 @@ func ValidateApp(app *AppInfo) error {
 		return fmt.Errorf(`"daemon" field contains invalid value %q`, app.Daemon)
 	}
 
+	switch app.DaemonScope {
+	case "", SystemDaemon, UserDaemon:
+		// valid, empty means system for daemons
+	default:
+		return fmt.Errorf(`"daemon-scope" field contains invalid value %q`, app.DaemonScope)
+	}
+
 	// Validate app name
 	if !ValidAppName(app.Name) {
 		return fmt.Errorf("cannot have %q as app name - use letters, digits, and dash as separator", app.Name)
@@ -605,14 +1963,45 @@ func ValidateApp(app *AppInfo) error {
 			return err
 		}
 	}
+	for _, name := range []string{"command", "stop-command", "reload-command", "post-stop-command"} {
+		if err := validateCommandArgs(name, checks[name]); err != nil {
+			return err
+		}
+	}
 
 	// Also validate the command chain
+	seen := make(map[string]bool, len(app.CommandChain))
 	for _, value := range app.CommandChain {
+		// the whitelist matches the empty string too
+		if value == "" {
+			return errors.New("command-chain entries cannot be empty")
+		}
 		if err := validateField("command-chain", value, commandChainContentWhitelist); err != nil {
 			return err
 		}
+		// a wrapper listed twice would run twice
+		if seen[value] {
+			return fmt.Errorf("command-chain contains %q more than once", value)
+		}
+		seen[value] = true
+	}
+
+	if app.Completer != "" {
+		if err := validateCompleter(app.Completer); err != nil {
+			return err
+		}
+	}
+
+	if app.BusName != "" {
+		if err := ValidateBusName(app.BusName); err != nil {
+			return err
+		}
 	}
 
+	// Sockets activate the service of the app, there must be one
+	if len(app.Sockets) > 0 && !app.IsService() {
+		return fmt.Errorf("cannot use sockets with application %q as it is not a service", app.Name)
+	}
 	// Socket activation requires the "network-bind" plug
 	if len(app.Sockets) > 0 {
 		if _, ok := app.Plugs["network-bind"]; !ok {
@@ -620,11 +2009,31 @@ func ValidateApp(app *AppInfo) error {
 		}
 	}
 
+	if err := validateAppSocketScopes(app); err != nil {
+		return err
+	}
+
 	for _, socket := range app.Sockets {
 		if err := validateAppSocket(socket); err != nil {
 			return fmt.Errorf("invalid definition of socket %q: %v", socket.Name, err)
 		}
 	}
+	// only system socket units are generated so far
+	if len(app.Sockets) > 0 && app.DaemonScope == UserDaemon {
+		return fmt.Errorf("cannot use sockets with application %q as it is a user daemon", app.Name)
+	}
+
+	if err := validateAppActivatesOn(app); err != nil {
+		return err
+	}
+
+	if err := validateAppEnvironment(app); err != nil {
+		return err
+	}
+
+	if err := validateAppDesktop(app); err != nil {
+		return err
+	}
 
 	if err := validateAppRestart(app); err != nil {
 		return err
@@ -651,16 +2060,91 @@ func ValidateApp(app *AppInfo) error {
 	default:
 		return fmt.Errorf(`"refresh-mode" field contains invalid value %q`, app.RefreshMode)
 	}
-	if app.StopMode != "" && app.Daemon == "" {
-		return fmt.Errorf(`"stop-mode" cannot be used for %q, only for services`, app.Name)
+	// validate install-mode
+	switch app.InstallMode {
+	case "", "enable", "disable":
+		// valid
+	default:
+		return fmt.Errorf(`"install-mode" field contains invalid value %q`, app.InstallMode)
 	}
-	if app.RefreshMode != "" && app.Daemon == "" {
-		return fmt.Errorf(`"refresh-mode" cannot be used for %q, only for services`, app.Name)
+
+	if err := validateDaemonFieldCombinations(app); err != nil {
+		return err
 	}
 
 	return validateAppTimer(app)
 }
 
+// validateDaemonFieldCombinations checks that the fields of the app that
+// depend on the kind of daemon are only used where they make sense:
+//
+//	field             | simple | forking | oneshot | dbus | notify
+//	------------------+--------+---------+---------+------+-------
+//	daemon-scope      |   x    |    x    |    x    |  x   |   x
+//	stop-mode         |   x    |    x    |    x    |  x   |   x
+//	refresh-mode      |   x    |    x    |    x    |  x   |   x
+//	post-stop-command |   x    |    x    |    x    |  x   |   x
+//	install-mode      |   x    |    x    |    x    |  x   |   x
+//	reload-command    |   x    |    x    |         |  x   |   x
+//	watchdog-timeout  |        |         |         |      |   x
+//	activates-on      |        |         |         |  x   |
+//
+// None of them can be used by apps that are not daemons. Additionally
+// "dbus" daemons must set "bus-name", and "refresh-mode: endure" cannot
+// be combined with "restart-condition: always".
+//
+// The values of the fields themselves are validated elsewhere.
+func validateDaemonFieldCombinations(app *AppInfo) error {
+	if app.Daemon == "" {
+		if app.DaemonScope != "" {
+			return fmt.Errorf(`"daemon-scope" can only be set for daemons`)
+		}
+		for _, field := range []struct {
+			name string
+			set  bool
+		}{
+			{"stop-mode", app.StopMode != ""},
+			{"refresh-mode", app.RefreshMode != ""},
+			{"post-stop-command", app.PostStopCommand != ""},
+			{"install-mode", app.InstallMode != ""},
+		} {
+			if field.set {
+				return fmt.Errorf(`%q cannot be used for %q, only for services`, field.name, app.Name)
+			}
+		}
+	}
+
+	// only long-running services can be told to reload
+	if app.ReloadCommand != "" {
+		switch app.Daemon {
+		case "simple", "forking", "notify", "dbus":
+			// valid
+		default:
+			return fmt.Errorf(`"reload-command" cannot be used for %q, only for long-running services (simple, forking, notify or dbus)`, app.Name)
+		}
+	}
+
+	// the watchdog is fed by sd_notify keep-alives
+	if app.WatchdogTimeout != 0 && app.Daemon != "notify" {
+		return fmt.Errorf(`watchdog-timeout requires "daemon: notify", not %q`, app.Daemon)
+	}
+
+	if app.Daemon == "dbus" && app.BusName == "" {
+		return fmt.Errorf(`"bus-name" must be set for "dbus" daemons`)
+	}
+	if len(app.ActivatesOn) > 0 && app.Daemon != "dbus" {
+		return fmt.Errorf("invalid activates-on value %q: only applicable to \"dbus\" daemons", app.ActivatesOn[0].Name)
+	}
+
+	// an enduring service is meant to keep running across refreshes,
+	// restarting it always fights that
+	if app.RefreshMode == "endure" && app.RestartCond == RestartAlways {
+		return fmt.Errorf(`"refresh-mode: endure" cannot be combined with "restart-condition: always" for %q`, app.Name)
+	}
+
+	return nil
+}
+
 // ValidatePathVariables ensures that given path contains only $SNAP, $SNAP_DATA or $SNAP_COMMON.
 func ValidatePathVariables(path string) error {
 	for path != "" {
@@ -696,9 +2180,18 @@ type LayoutConstraint interface {
 // mountedTree represents a mounted file-system tree or a bind-mounted directory.
 type mountedTree string
 
+// isPathPrefix returns true if prefix is a (perhaps non-proper) prefix of
+// path, component-wise.
+func isPathPrefix(prefix, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
 // IsOffLimits returns true if the mount point is (perhaps non-proper) prefix of a given path.
 func (mountPoint mountedTree) IsOffLimits(path string) bool {
-	return strings.HasPrefix(path, string(mountPoint)+"/") || path == string(mountPoint)
+	return isPathPrefix(string(mountPoint), path)
 }
 
 // mountedFile represents a bind-mounted file.
@@ -706,7 +2199,7 @@ type mountedFile string
 
 // IsOffLimits returns true if the mount point is (perhaps non-proper) prefix of a given path.
 func (mountPoint mountedFile) IsOffLimits(path string) bool {
-	return strings.HasPrefix(path, string(mountPoint)+"/") || path == string(mountPoint)
+	return isPathPrefix(string(mountPoint), path)
 }
 
 // symlinkFile represents a layout using symbolic link.
@@ -714,7 +2207,7 @@ type symlinkFile string
 
 // IsOffLimits returns true for mounted files  if a path is identical to the path of the mount point.
 func (mountPoint symlinkFile) IsOffLimits(path string) bool {
-	return strings.HasPrefix(path, string(mountPoint)+"/") || path == string(mountPoint)
+	return isPathPrefix(string(mountPoint), path)
 }
 
 func (layout *Layout) constraint() LayoutConstraint {
@@ -727,8 +2220,18 @@ func (layout *Layout) constraint() LayoutConstraint {
 	return mountedTree(path)
 }
 
+// OffLimitsLayoutPaths are the directories that layouts cannot be placed in
+// by default.
+var OffLimitsLayoutPaths = []string{"/proc", "/sys", "/dev", "/run", "/boot", "/lost+found", "/media", "/var/lib/snapd", "/var/snap", "/lib/firmware", "/lib/modules"}
+
 // ValidateLayout ensures that the given layout contains only valid subset of constructs.
 func ValidateLayout(layout *Layout, constraints []LayoutConstraint) error {
+	return ValidateLayoutWith(layout, constraints, OffLimitsLayoutPaths)
+}
+
+// ValidateLayoutWith is like ValidateLayout but uses the given list of
+// off-limits directories instead of OffLimitsLayoutPaths.
+func ValidateLayoutWith(layout *Layout, constraints []LayoutConstraint, offLimits []string) error {
 	si := layout.Snap
 	// Rules for validating layouts:
 	//
@@ -750,13 +2253,20 @@ func ValidateLayout(layout *Layout, constraints []LayoutConstraint) error {
 		return fmt.Errorf("layout %q uses invalid mount point: must be absolute and clean", layout.Path)
 	}
 
-	for _, path := range []string{"/proc", "/sys", "/dev", "/run", "/boot", "/lost+found", "/media", "/var/lib/snapd", "/var/snap", "/lib/firmware", "/lib/modules"} {
+	for _, path := range offLimits {
 		// We use the mountedTree constraint as this has the right semantics.
 		if mountedTree(path).IsOffLimits(mountPoint) {
 			return fmt.Errorf("layout %q in an off-limits area", layout.Path)
 		}
 	}
 
+	// Layouts cannot hide the directories of the snap itself.
+	for _, dir := range []string{"$SNAP", "$SNAP_DATA", "$SNAP_COMMON"} {
+		if mountPoint == "/" || isPathPrefix(mountPoint, si.ExpandSnapVariables(dir)) {
+			return fmt.Errorf("layout %q shadows the snap's own %s directory", layout.Path, dir)
+		}
+	}
+
 	for _, constraint := range constraints {
 		if constraint.IsOffLimits(mountPoint) {
 			return fmt.Errorf("layout %q underneath prior layout item %q", layout.Path, constraint)
@@ -797,6 +2307,9 @@ func ValidateLayout(layout *Layout, constraints []LayoutConstraint) error {
 			!strings.HasPrefix(mountSource, si.ExpandSnapVariables("$SNAP_COMMON")) {
 			return fmt.Errorf("layout %q uses invalid bind mount source %q: must start with $SNAP, $SNAP_DATA or $SNAP_COMMON", layout.Path, mountSource)
 		}
+		if mountSource == mountPoint {
+			return fmt.Errorf("layout %q uses bind mount source %q which is the same as the mount point (both expand to %q)", layout.Path, layout.Bind+layout.BindFile, mountPoint)
+		}
 	}
 
 	switch layout.Type {
@@ -824,33 +2337,111 @@ func ValidateLayout(layout *Layout, constraints []LayoutConstraint) error {
 			!strings.HasPrefix(oldname, si.ExpandSnapVariables("$SNAP_COMMON")) {
 			return fmt.Errorf("layout %q uses invalid symlink old name %q: must start with $SNAP, $SNAP_DATA or $SNAP_COMMON", layout.Path, oldname)
 		}
+		// The permissions and ownership of symlinks are not used, anything
+		// but the defaults is likely left over from another kind of layout.
+		if layout.Mode != 0 && layout.Mode != 0755 {
+			return fmt.Errorf("layout %q uses a symlink and cannot set mode %#o", layout.Path, layout.Mode)
+		}
+		if layout.User != "" && layout.User != "root" {
+			return fmt.Errorf("layout %q uses a symlink and cannot set user %q", layout.Path, layout.User)
+		}
+		if layout.Group != "" && layout.Group != "root" {
+			return fmt.Errorf("layout %q uses a symlink and cannot set group %q", layout.Path, layout.Group)
+		}
 	}
 
 	// When new users and groups are supported those must be added to interfaces/mount/spec.go as well.
-	// For now only "root" is allowed (and default).
-
-	switch layout.User {
-	case "root", "":
-	// TODO: allow declared snap user and group names.
-	default:
-		return fmt.Errorf("layout %q uses invalid user %q", layout.Path, layout.User)
+	// Besides "root" (the default) only users declared in system-usernames,
+	// and the groups of the same name, are allowed.
+	if err := validateLayoutOwner(layout, "user", layout.User); err != nil {
+		return err
 	}
-	switch layout.Group {
-	case "root", "":
-	default:
-		return fmt.Errorf("layout %q uses invalid group %q", layout.Path, layout.Group)
+	if err := validateLayoutOwner(layout, "group", layout.Group); err != nil {
+		return err
 	}
 
 	if layout.Mode&01777 != layout.Mode {
 		return fmt.Errorf("layout %q uses invalid mode %#o", layout.Path, layout.Mode)
 	}
+
+	return validateLayoutOptions(layout)
+}
+
+// supportedLayoutOptions are the mount options layouts can use.
+var supportedLayoutOptions = []string{"ro", "rw"}
+
+func validateLayoutOptions(layout *Layout) error {
+	if len(layout.Options) == 0 {
+		return nil
+	}
+	if layout.Symlink != "" {
+		return fmt.Errorf("layout %q uses a symlink and cannot set options", layout.Path)
+	}
+	seen := make(map[string]bool, len(layout.Options))
+	for _, option := range layout.Options {
+		if !strutil.ListContains(supportedLayoutOptions, option) {
+			return fmt.Errorf("layout %q uses unsupported option %q (supported: %s)", layout.Path, option, strings.Join(supportedLayoutOptions, ", "))
+		}
+		seen[option] = true
+	}
+	if seen["ro"] && seen["rw"] {
+		return fmt.Errorf("layout %q cannot be both read-only and read-write", layout.Path)
+	}
+	return nil
+}
+
+// supportedSystemUsernames are the system usernames snaps can declare.
+var supportedSystemUsernames = []string{"snap_daemon"}
+
+// ValidateSystemUsernames checks that the system usernames declared by
+// the snap are supported and use a known scope.
+func ValidateSystemUsernames(info *Info) error {
+	names := make([]string, 0, len(info.SystemUsernames))
+	for name := range info.SystemUsernames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !strutil.ListContains(supportedSystemUsernames, name) {
+			return fmt.Errorf("system username %q is not supported (supported: %s)", name, strings.Join(supportedSystemUsernames, ", "))
+		}
+		switch scope := info.SystemUsernames[name].Scope; scope {
+		case "", "shared", "private", "external":
+			// valid
+		default:
+			return fmt.Errorf("system username %q has invalid scope %q", name, scope)
+		}
+	}
+	return nil
+}
+
+var validSystemUsername = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+func validateLayoutOwner(layout *Layout, kind, name string) error {
+	if name == "" || name == "root" {
+		return nil
+	}
+	if !validSystemUsername.MatchString(name) {
+		return fmt.Errorf("layout %q uses invalid %s %q", layout.Path, kind, name)
+	}
+	if _, ok := layout.Snap.SystemUsernames[name]; !ok {
+		return fmt.Errorf("layout %q uses invalid %s %q: must be root or declared in system-usernames", layout.Path, kind, name)
+	}
 	return nil
 }
 
+// validCommonID matches reverse-DNS style AppStream component IDs.
+var validCommonID = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)+$`)
+
 func ValidateCommonIDs(info *Info) error {
 	seen := make(map[string]string, len(info.Apps))
 	for _, app := range info.Apps {
 		if app.CommonID != "" {
+			if !validCommonID.MatchString(app.CommonID) {
+				return fmt.Errorf("application %q common-id %q must be a reverse-DNS style AppStream component ID (e.g. org.example.foo)",
+					app.Name, app.CommonID)
+			}
 			if other, was := seen[app.CommonID]; was {
 				return fmt.Errorf("application %q common-id %q must be unique, already used by application %q",
 					app.Name, app.CommonID, other)
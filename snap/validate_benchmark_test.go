@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+// manyAppsYaml returns the snap.yaml of a snap with n apps, each with a
+// service, a plug, a slot, a common-id and a layout.
+func manyAppsYaml(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("name: many\nversion: 1.0\napps:\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "  app%d:\n    command: bin/app%d\n    daemon: simple\n    common-id: org.example.app%d\n    plugs: [plug%d]\n    slots: [slot%d]\n", i, i, i, i, i)
+	}
+	buf.WriteString("plugs:\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "  plug%d:\n    interface: content\n    target: $SNAP/plug%d\n", i, i)
+	}
+	buf.WriteString("slots:\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "  slot%d:\n    interface: content\n    read: [$SNAP/slot%d]\n", i, i)
+	}
+	buf.WriteString("layout:\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "  /usr/share/app%d:\n    bind: $SNAP/usr/share/app%d\n", i, i)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkValidateManyApps(b *testing.B) {
+	defer snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {})()
+
+	info, err := snap.InfoFromSnapYaml(manyAppsYaml(500))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := snap.Validate(info); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		snap.Validate(info)
+	}
+}
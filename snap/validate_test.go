@@ -24,12 +24,16 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	. "gopkg.in/check.v1"
 
 	. "github.com/snapcore/snapd/snap"
 
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/testutil"
+	"github.com/snapcore/snapd/timeout"
 )
 
 type ValidateSuite struct {
@@ -50,8 +54,9 @@ func createSampleApp() *AppInfo {
 				Revision: R(20),
 			},
 		},
-		Name:  "foo",
-		Plugs: map[string]*PlugInfo{"network-bind": {}},
+		Name:   "foo",
+		Daemon: "simple",
+		Plugs:  map[string]*PlugInfo{"network-bind": {}},
 		Sockets: map[string]*SocketInfo{
 			"sock": socket,
 		},
@@ -69,6 +74,41 @@ func (s *ValidateSuite) TearDownTest(c *C) {
 	s.BaseTest.TearDownTest(c)
 }
 
+func (s *ValidateSuite) TestValidateSnapID(c *C) {
+	c.Check(ValidateSnapID("buPKUD3TKqCOgLEjjHx5kSiCpIs5cMuQ"), IsNil)
+
+	c.Check(ValidateSnapID(""), ErrorMatches, `invalid snap-id "": must be 32 characters long, got 0`)
+	c.Check(ValidateSnapID("buPKUD3TKqCOgLEjjHx5kSiCpIs5cMu"), ErrorMatches, `invalid snap-id "buPKUD3TKqCOgLEjjHx5kSiCpIs5cMu": must be 32 characters long, got 31`)
+	c.Check(ValidateSnapID("buPKUD3TKqCOgLEjjHx5kSiCpIs5cMuQx"), ErrorMatches, `invalid snap-id ".*": must be 32 characters long, got 33`)
+	c.Check(ValidateSnapID("buPKUD3TKqCOgLEj-Hx5kSiCpIs5cMuQ"), ErrorMatches, `invalid snap-id "buPKUD3TKqCOgLEj-Hx5kSiCpIs5cMuQ": contains invalid character '-'`)
+	c.Check(ValidateSnapID("buPKUD3TKqCOgLEj/Hx5kSiCpIs5cMuQ"), ErrorMatches, `invalid snap-id ".*": contains invalid character '/'`)
+	// length is in bytes, so this is caught as too long
+	c.Check(ValidateSnapID("buPKUD3TKqCOgLEjéHx5kSiCpIs5cMuQ"), ErrorMatches, `invalid snap-id ".*": must be 32 characters long, got 33`)
+}
+
+func (s *ValidateSuite) TestSuggestName(c *C) {
+	for _, t := range []struct{ input, name string }{
+		{"My Cool App!", "my-cool-app"},
+		{"foo", "foo"},
+		{"--Foo__Bar--", "foo-bar"},
+		{"2048 Game", "game"},
+		{"app 2 go 3", "app-2-go"},
+		{"1-or-2-things", "or-2-things"},
+		{"très bien", "tr-s-bien"},
+		{strings.Repeat("abcd-", 10), "abcd-abcd-abcd-abcd-abcd-abcd-abcd-abcd"},
+	} {
+		name, err := SuggestName(t.input)
+		c.Assert(err, IsNil, Commentf(t.input))
+		c.Check(name, Equals, t.name)
+		c.Check(ValidateName(name), IsNil)
+	}
+
+	for _, input := range []string{"", "!!!", "123 456", "a", "日本語"} {
+		_, err := SuggestName(input)
+		c.Check(err, ErrorMatches, `cannot suggest a snap name for ".*"`)
+	}
+}
+
 func (s *ValidateSuite) TestValidateVersion(c *C) {
 	validVersions := []string{
 		"0", "v1.0", "0.12+16.04.20160126-0ubuntu1",
@@ -113,6 +153,91 @@ func (s *ValidateSuite) TestValidateVersion(c *C) {
 		`invalid snap version "this-version-is-a-little-bit-older": cannot be longer than 32 characters \(got: 34\)`)
 }
 
+func (s *ValidateSuite) TestValidateVersionMaxLength(c *C) {
+	c.Check(ValidateVersion(strings.Repeat("1", MaxVersionLength)), IsNil)
+	c.Check(ValidateVersion("1"+strings.Repeat(".", MaxVersionLength-2)+"1"), IsNil)
+
+	tooLong := strings.Repeat("1", MaxVersionLength+1)
+	c.Check(ValidateVersion(tooLong), ErrorMatches,
+		fmt.Sprintf(`invalid snap version %q: cannot be longer than %d characters \(got: %d\)`, tooLong, MaxVersionLength, MaxVersionLength+1))
+}
+
+func (s *ValidateSuite) TestValidateVersionStrict(c *C) {
+	validVersions := []string{
+		"0.0.0", "1.2.3", "10.20.30", "1.2.3-rc1", "1.2.3+git123", "1.2.3-rc1+git123",
+	}
+	for _, version := range validVersions {
+		c.Check(ValidateVersionStrict(version), IsNil, Commentf(version))
+	}
+	invalidVersionsTable := [][2]string{
+		{"banana", `must be of the form major.minor.patch \(got 1 components\)`},
+		{"1.2", `must be of the form major.minor.patch \(got 2 components\)`},
+		{"1.2.3.4", `must be of the form major.minor.patch \(got 4 components\)`},
+		{"1.2.x", `patch component "x" is not a number`},
+		{"a.2.3", `major component "a" is not a number`},
+		{"1.b.3", `minor component "b" is not a number`},
+		{"1.02.3", `minor component "02" cannot have leading zeros`},
+	}
+	for _, t := range invalidVersionsTable {
+		version, reason := t[0], t[1]
+		c.Check(ValidateVersionStrict(version), ErrorMatches, fmt.Sprintf(`invalid snap version %q: %s`, version, reason))
+	}
+	// the basic checks still apply
+	c.Check(ValidateVersionStrict("~1.2.3"), ErrorMatches, `invalid snap version "~1.2.3": must start with an ASCII alphanumeric.*`)
+
+	// non-strict mode is the same as ValidateVersion
+	c.Check(ValidateVersionWith("banana", VersionOptions{}), IsNil)
+	c.Check(ValidateVersionWith("1.2.x", VersionOptions{}), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateVersionNoColons(c *C) {
+	for _, version := range []string{"1:2.3", "2.3:git1", "a:b"} {
+		// allowed by default
+		c.Check(ValidateVersion(version), IsNil, Commentf(version))
+		c.Check(ValidateVersionWith(version, VersionOptions{}), IsNil, Commentf(version))
+
+		c.Check(ValidateVersionWith(version, VersionOptions{NoColons: true}), ErrorMatches,
+			fmt.Sprintf(`invalid snap version %q: colons are discouraged as they can be mistaken for an epoch`, version))
+	}
+	c.Check(ValidateVersionWith("1.2.3", VersionOptions{NoColons: true}), IsNil)
+	c.Check(ValidateVersionWith("1.2.3", VersionOptions{NoColons: true, Semantic: true}), IsNil)
+	c.Check(ValidateVersionWith("1:2.3.4", VersionOptions{NoColons: true, Semantic: true}), ErrorMatches,
+		`invalid snap version "1:2.3.4": colons are discouraged .*`)
+}
+
+func (s *ValidateSuite) TestValidateProvenance(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+provenance: delegated-prov-1
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.Provenance(), Equals, "delegated-prov-1")
+	c.Check(Validate(info), IsNil)
+
+	// unset means the default
+	info, err = InfoFromSnapYaml([]byte("name: foo\nversion: 1.0\n"))
+	c.Assert(err, IsNil)
+	c.Check(info.Provenance(), Equals, DefaultProvenance)
+	c.Check(ValidateProvenance(info), IsNil)
+
+	// present but empty
+	_, err = InfoFromSnapYaml([]byte("name: foo\nversion: 1.0\nprovenance: ''\n"))
+	c.Check(err, ErrorMatches, `provenance cannot be empty, omit it to use the default`)
+
+	for _, prov := range []string{"global-upload", "a", "A1", strings.Repeat("a", 255)} {
+		c.Check(ValidateProvenance(&Info{SnapProvenance: prov}), IsNil, Commentf(prov))
+	}
+	for _, prov := range []string{"-foo", "foo-", "foo--bar", "foo_bar", "foo.bar", "foo bar", "føø"} {
+		c.Check(ValidateProvenance(&Info{SnapProvenance: prov}), ErrorMatches,
+			`invalid provenance ".*": must be made of ASCII letters and digits separated by single dashes`, Commentf(prov))
+	}
+	c.Check(ValidateProvenance(&Info{SnapProvenance: strings.Repeat("a", 256)}), ErrorMatches,
+		`invalid provenance: cannot be longer than 255 characters \(got: 256\)`)
+
+	info.SnapProvenance = "bad--prov"
+	c.Check(Validate(info), ErrorMatches, `invalid provenance "bad--prov": .*`)
+}
+
 func (s *ValidateSuite) TestValidateLicense(c *C) {
 	validLicenses := []string{
 		"GPL-3.0", "(GPL-3.0)", "GPL-3.0+", "GPL-3.0 AND GPL-2.0", "GPL-3.0 OR GPL-2.0", "MIT OR (GPL-3.0 AND GPL-2.0)", "MIT OR(GPL-3.0 AND GPL-2.0)",
@@ -130,6 +255,93 @@ func (s *ValidateSuite) TestValidateLicense(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestValidateAppHookNameCollision(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  configure:
+    command: bin/configure
+  install:
+    command: bin/install
+hooks:
+  configure:
+  remove:
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `application "configure" and hook "configure" cannot have the same name`)
+
+	delete(info.Apps, "configure")
+	c.Check(Validate(info), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateLinks(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+links:
+  website:
+    - https://example.com/foo
+  contact:
+    - mailto:foo@example.com
+    - https://example.com/foo/support
+  source-code:
+    - http://git.example.com/foo.git
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.Links, HasLen, 3)
+	c.Check(ValidateLinks(info), IsNil)
+	c.Check(Validate(info), IsNil)
+	c.Check(ValidateLinks(&Info{}), IsNil)
+
+	for _, t := range []struct {
+		key, link string
+		err       string
+	}{
+		{"website", "example.com", `invalid "website" link "example.com": missing scheme`},
+		{"website", "javascript:alert(1)", `invalid "website" link "javascript:alert\(1\)": unsupported scheme "javascript"`},
+		{"website", "ftp://example.com", `invalid "website" link "ftp://example.com": unsupported scheme "ftp"`},
+		{"website", "https:///foo", `invalid "website" link "https:///foo": missing host`},
+		{"website", "http://exa mple.com", `invalid "website" link "http://exa mple.com": not a valid URL`},
+		{"website", "", `invalid "website" link "": cannot be empty`},
+		{"website", "mailto:foo@example.com", `invalid "website" link "mailto:foo@example.com": "mailto" is only allowed for contact links`},
+		{"contact", "mailto:", `invalid "contact" link "mailto:": missing address`},
+		{"Website", "https://example.com", `invalid links key "Website"`},
+		{"source--code", "https://example.com", `invalid links key "source--code"`},
+	} {
+		info := &Info{Links: map[string][]string{t.key: {t.link}}}
+		c.Check(ValidateLinks(info), ErrorMatches, t.err, Commentf("%s: %s", t.key, t.link))
+	}
+
+	info.Links["website"] = append(info.Links["website"], "javascript:void(0)")
+	c.Check(Validate(info), ErrorMatches, `invalid "website" link "javascript:void\(0\)": unsupported scheme "javascript"`)
+}
+
+func (s *ValidateSuite) TestValidateLicenses(c *C) {
+	c.Check(ValidateLicenses(nil), IsNil)
+	c.Check(ValidateLicenses([]string{"GPL-3.0", "MIT OR Apache-2.0"}), IsNil)
+
+	err := ValidateLicenses([]string{"GPL-3.0", "MIT OR Apache-2.0", "GPL~3.0", "FOO"})
+	c.Check(err, ErrorMatches, `invalid license at index 2: cannot validate license "GPL~3.0": unknown license: GPL~3.0 .*`)
+	err = ValidateLicenses([]string{""})
+	c.Check(err, ErrorMatches, `invalid license at index 0: cannot validate license "": empty expression .*`)
+}
+
+func (s *ValidateSuite) TestValidateLicenseErrorPosition(c *C) {
+	for _, t := range []struct {
+		license string
+		err     string
+	}{
+		{"(MIT OR Apache-2.0) AND GPL~3.0+", `unknown license: GPL~3.0\+ \(at "GPL~3.0\+", offset 24\)`},
+		{"(MIT OR FOO) AND GPL-3.0+", `unknown license: FOO \(at "FOO", offset 8\)`},
+		{"MIT AND OR GPL-3.0", `expected license name, got "OR" \(at "OR", offset 8\)`},
+		{"GPL-3.0 WITH nothing", `unknown license exception: nothing \(at "nothing", offset 13\)`},
+		{"(MIT OR GPL-3.0", `expected "\)" got "" \(at end of expression\)`},
+		{"MIT AND", `missing license after AND \(at end of expression\)`},
+	} {
+		err := ValidateLicense(t.license)
+		c.Check(err, ErrorMatches, fmt.Sprintf(`cannot validate license %s: %s`, regexp.QuoteMeta(strconv.Quote(t.license)), t.err), Commentf(t.license))
+	}
+}
+
 func (s *ValidateSuite) TestValidateHook(c *C) {
 	validHooks := []*HookInfo{
 		{Name: "a"},
@@ -169,6 +381,133 @@ func (s *ValidateSuite) TestValidateHook(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestValidateHookCommandChainEmptyEntry(c *C) {
+	hook := &HookInfo{Name: "configure", CommandChain: []string{"bin/wrapper", ""}}
+	c.Check(ValidateHook(hook), ErrorMatches, `hook command-chain entries cannot be empty`)
+}
+
+func (s *ValidateSuite) TestValidateHookCommandChainDuplicates(c *C) {
+	hook := &HookInfo{Name: "configure", CommandChain: []string{"bin/wrapper", "bin/other", "bin/wrapper"}}
+	c.Check(ValidateHook(hook), ErrorMatches, `hook command-chain contains "bin/wrapper" more than once`)
+}
+
+func (s *ValidateSuite) TestValidateHookPlugs(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+plugs:
+  network:
+hooks:
+  configure:
+    plugs: [network, home]
+`))
+	c.Assert(err, IsNil)
+	hook := info.Hooks["configure"]
+	c.Check(ValidateHook(hook), IsNil)
+
+	// a plug that is not (or no longer) defined by the snap
+	delete(info.Plugs, "home")
+	c.Check(ValidateHook(hook), ErrorMatches, `hook "configure" references undefined plug "home"`)
+	c.Check(Validate(info), ErrorMatches, `hook "configure" references undefined plug "home"`)
+}
+
+func (s *ValidateSuite) TestValidatePlugSlotAttrs(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+plugs:
+  p:
+    interface: content
+    target: $SNAP/foo
+    nested: {a: [1, 2.5, true, {b: c}]}
+slots:
+  s:
+    interface: content
+    read: [$SNAP/bar]
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), IsNil)
+
+	deep := interface{}("leaf")
+	for i := 0; i < 16; i++ {
+		deep = []interface{}{deep}
+	}
+
+	for _, tc := range []struct {
+		plugAttrs, slotAttrs map[string]interface{}
+		err                  string
+	}{
+		{map[string]interface{}{"f": func() {}}, nil, `plug "p" has invalid attribute "f": unsupported type func\(\)`},
+		{nil, map[string]interface{}{"c": make(chan int)}, `slot "s" has invalid attribute "c": unsupported type chan int`},
+		{map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x", struct{}{}}}}, nil, `plug "p" has invalid attribute "a.b\[1\]": unsupported type struct {}`},
+		{map[string]interface{}{"a": map[interface{}]interface{}{"b": "c"}}, nil, `plug "p" has invalid attribute "a": unsupported type map\[interface {}\]interface {}`},
+		{map[string]interface{}{"deep": deep}, nil, `plug "p" has invalid attribute "deep(\[0\]){16}": nested too deeply \(max 16 levels\)`},
+		{nil, map[string]interface{}{"big": strings.Repeat("x", 64*1024)}, `slot "s" attributes are too large \(65546 bytes, max 65536\)`},
+	} {
+		info.Plugs["p"].Attrs = tc.plugAttrs
+		info.Slots["s"].Attrs = tc.slotAttrs
+		c.Check(Validate(info), ErrorMatches, tc.err)
+	}
+
+	// the maximum depth itself is fine
+	info.Plugs["p"].Attrs = map[string]interface{}{"deep": deep.([]interface{})[0]}
+	info.Slots["s"].Attrs = nil
+	c.Check(Validate(info), IsNil)
+}
+
+func (s *ValidateSuite) TestValidatePlugSlotRefs(c *C) {
+	yaml := `name: foo
+version: 1.0
+plugs:
+  net:
+    interface: network
+slots:
+  dbus-svc:
+    interface: dbus
+    bus: session
+    name: org.foo
+apps:
+  app:
+    plugs: [net, home]
+    slots: [dbus-svc, mpris]
+hooks:
+  install:
+    plugs: [net]
+    slots: [dbus-svc]
+`
+	for _, tc := range []struct {
+		plug, slot string
+		err        string
+	}{
+		{"", "", ""},
+		{"home", "", `application "app" references undefined plug "home"`},
+		{"", "mpris", `application "app" references undefined slot "mpris"`},
+		{"net", "", `application "app" references undefined plug "net"`},
+		{"", "dbus-svc", `application "app" references undefined slot "dbus-svc"`},
+	} {
+		info, err := InfoFromSnapYaml([]byte(yaml))
+		c.Assert(err, IsNil)
+		// referencing plugs and slots by name defines them implicitly,
+		// drop them to simulate broken references
+		if tc.plug != "" {
+			delete(info.Plugs, tc.plug)
+		}
+		if tc.slot != "" {
+			delete(info.Slots, tc.slot)
+		}
+		errs := ValidateAll(info)
+		if tc.err == "" {
+			c.Check(errs, HasLen, 0)
+			continue
+		}
+		c.Assert(errs, Not(HasLen), 0)
+		c.Check(errs[0], ErrorMatches, tc.err)
+	}
+
+	info, err := InfoFromSnapYaml([]byte(yaml))
+	c.Assert(err, IsNil)
+	delete(info.Slots, "dbus-svc")
+	c.Check(ValidateHook(info.Hooks["install"]), ErrorMatches, `hook "install" references undefined slot "dbus-svc"`)
+}
+
 // ValidateApp
 
 func (s *ValidateSuite) TestValidateAppSockets(c *C) {
@@ -189,6 +528,30 @@ func (s *ValidateSuite) TestValidateAppSocketsWrongPerms(c *C) {
 	c.Assert(err, ErrorMatches, `invalid definition of socket "sock": cannot use mode: 2322`)
 }
 
+func (s *ValidateSuite) TestValidateAppSocketsModeOnlyForPaths(c *C) {
+	app := createSampleApp()
+	socket := app.Sockets["sock"]
+	socket.SocketMode = 0600
+	socket.ListenDatagram = "$SNAP_DATA/dgram.socket"
+	c.Check(ValidateApp(app), IsNil)
+
+	for _, t := range []struct{ stream, datagram, err string }{
+		{"@snap.mysnap.my.socket", "", `"listen-stream" "@snap.mysnap.my.socket"`},
+		{"8080", "", `"listen-stream" "8080"`},
+		{"[::1]:8080", "", `"listen-stream" "\[::1\]:8080"`},
+		{"", "127.0.0.1:5353", `"listen-datagram" "127.0.0.1:5353"`},
+		{"$SNAP_DATA/my.socket", "53", `"listen-datagram" "53"`},
+	} {
+		socket.ListenStream = t.stream
+		socket.ListenDatagram = t.datagram
+		c.Check(ValidateApp(app), ErrorMatches, `invalid definition of socket "sock": cannot use socket-mode with `+t.err+`, it only applies to sockets bound to a path`)
+	}
+
+	// without socket-mode they are all fine
+	socket.SocketMode = 0
+	c.Check(ValidateApp(app), IsNil)
+}
+
 func (s *ValidateSuite) TestValidateAppSocketsMissingNetworkBindPlug(c *C) {
 	app := createSampleApp()
 	delete(app.Plugs, "network-bind")
@@ -198,6 +561,13 @@ func (s *ValidateSuite) TestValidateAppSocketsMissingNetworkBindPlug(c *C) {
 		`"network-bind" interface plug is required when sockets are used`)
 }
 
+func (s *ValidateSuite) TestValidateAppSocketsNotAService(c *C) {
+	app := createSampleApp()
+	app.Daemon = ""
+	err := ValidateApp(app)
+	c.Assert(err, ErrorMatches, `cannot use sockets with application "foo" as it is not a service`)
+}
+
 func (s *ValidateSuite) TestValidateAppSocketsEmptyListenStream(c *C) {
 	app := createSampleApp()
 	app.Sockets["sock"].ListenStream = ""
@@ -249,7 +619,7 @@ func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamPath(c *C) {
 	for _, invalidAddress := range invalidListenAddresses {
 		socket.ListenStream = invalidAddress
 		err := ValidateApp(app)
-		c.Assert(err, ErrorMatches, `invalid definition of socket "sock": invalid "listen-stream": must have a prefix of .*`)
+		c.Assert(err, ErrorMatches, `invalid definition of socket "sock": invalid "listen-stream": daemon sockets must have a prefix of .*`)
 	}
 }
 
@@ -267,6 +637,7 @@ func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamPathPrefix(c *C
 	invalidListenAddresses := []string{
 		"$SNAP/my.socket", // snap dir is not writable
 		"$SOMEVAR/my.socket",
+		"$SNAP_USER_DATA/my.socket", // not expanded in socket units
 	}
 	socket := app.Sockets["sock"]
 	for _, invalidAddress := range invalidListenAddresses {
@@ -274,8 +645,102 @@ func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamPathPrefix(c *C
 		err := ValidateApp(app)
 		c.Assert(
 			err, ErrorMatches,
-			`invalid definition of socket "sock": invalid "listen-stream": must have a prefix of \$SNAP_DATA, \$SNAP_COMMON or \$XDG_RUNTIME_DIR`)
+			`invalid definition of socket "sock": invalid "listen-stream": daemon sockets must have a prefix of \$SNAP_DATA, \$SNAP_COMMON or \$XDG_RUNTIME_DIR`)
+	}
+}
+
+func (s *ValidateSuite) TestValidateAppSocketsSystemDaemonListenStreamPath(c *C) {
+	app := createSampleApp()
+	app.DaemonScope = SystemDaemon
+	socket := app.Sockets["sock"]
+
+	for _, validAddress := range []string{
+		"$SNAP_DATA/my.socket",
+		"$SNAP_COMMON/my.socket",
+	} {
+		socket.ListenStream = validAddress
+		c.Check(ValidateApp(app), IsNil, Commentf(validAddress))
+	}
+
+	for _, invalidAddress := range []string{
+		"$XDG_RUNTIME_DIR/my.socket", // only without a declared scope
+		"$SNAP/my.socket",
+	} {
+		socket.ListenStream = invalidAddress
+		c.Check(ValidateApp(app), ErrorMatches,
+			`invalid definition of socket "sock": invalid "listen-stream": system daemon sockets must have a prefix of \$SNAP_DATA or \$SNAP_COMMON`,
+			Commentf(invalidAddress))
+	}
+}
+
+func (s *ValidateSuite) TestValidateAppSocketsMixedScopes(c *C) {
+	// without a declared scope the runtime directory is the one of root,
+	// so it is as system-wide as the data directories
+	app := createSampleApp()
+	app.Sockets["other"] = &SocketInfo{App: app, Name: "other", ListenStream: "$XDG_RUNTIME_DIR/other.socket"}
+	app.Sockets["net"] = &SocketInfo{App: app, Name: "net", ListenStream: "8080"}
+	c.Check(ValidateApp(app), IsNil)
+
+	// user daemons cannot have sockets yet, but their runtime directory
+	// is the one of the user
+	app.DaemonScope = UserDaemon
+	c.Check(ValidateAppSocketScopes(app), ErrorMatches, `socket "other" uses user scope but socket "sock" uses system scope, all sockets of app "foo" must be in the same scope`)
+
+	// the scope of datagram sockets counts too
+	app.Sockets["other"].ListenStream = ""
+	app.Sockets["other"].ListenDatagram = "$XDG_RUNTIME_DIR/other.socket"
+	c.Check(ValidateAppSocketScopes(app), ErrorMatches, `socket "other" uses user scope but socket "sock" uses system scope, .*`)
+
+	app.Sockets["sock"].ListenStream = "$XDG_RUNTIME_DIR/sock.socket"
+	c.Check(ValidateAppSocketScopes(app), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateAppSocketsUserDaemon(c *C) {
+	app := createSampleApp()
+	app.DaemonScope = UserDaemon
+	socket := app.Sockets["sock"]
+
+	// socket units are only generated for system daemons
+	for _, address := range []string{
+		"$XDG_RUNTIME_DIR/my.socket",
+		"@snap.mysnap.my.socket",
+		"8080",
+	} {
+		socket.ListenStream = address
+		c.Check(ValidateApp(app), ErrorMatches, `cannot use sockets with application "foo" as it is a user daemon`, Commentf(address))
+	}
+
+	delete(app.Sockets, "sock")
+	c.Check(ValidateApp(app), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateAppDaemonScope(c *C) {
+	for _, scope := range []DaemonScope{"", SystemDaemon, UserDaemon} {
+		app := &AppInfo{Name: "foo", Daemon: "simple", DaemonScope: scope}
+		c.Check(ValidateApp(app), IsNil, Commentf(string(scope)))
 	}
+
+	app := &AppInfo{Name: "foo", Daemon: "simple", DaemonScope: "session"}
+	c.Check(ValidateApp(app), ErrorMatches, `"daemon-scope" field contains invalid value "session"`)
+
+	app = &AppInfo{Name: "foo", DaemonScope: UserDaemon}
+	c.Check(ValidateApp(app), ErrorMatches, `"daemon-scope" can only be set for daemons`)
+
+	// system daemons cannot use the runtime directory of a user
+	app = createSampleApp()
+	app.Daemon = "simple"
+	app.DaemonScope = SystemDaemon
+	app.Sockets["sock"].ListenStream = "$XDG_RUNTIME_DIR/my.socket"
+	c.Check(ValidateApp(app), ErrorMatches, `invalid definition of socket "sock": invalid "listen-stream": system daemon sockets must have a prefix of \$SNAP_DATA or \$SNAP_COMMON`)
+	// and user daemons cannot use the system-wide data directories
+	app.DaemonScope = UserDaemon
+	for _, path := range []string{"$SNAP_DATA/my.socket", "$SNAP_COMMON/my.socket"} {
+		app.Sockets["sock"].ListenStream = path
+		c.Check(ValidateApp(app), ErrorMatches, `invalid definition of socket "sock": invalid "listen-stream": user daemon sockets must have a prefix of \$XDG_RUNTIME_DIR`, Commentf(path))
+	}
+	// but the sockets of user daemons are not supported yet
+	app.Sockets["sock"].ListenStream = "$XDG_RUNTIME_DIR/my.socket"
+	c.Check(ValidateApp(app), ErrorMatches, `cannot use sockets with application "foo" as it is a user daemon`)
 }
 
 func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamAbstractSocket(c *C) {
@@ -311,6 +776,54 @@ func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamAddress(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestValidateAppSocketsMalformedListenStreamAddress(c *C) {
+	app := createSampleApp()
+	for _, t := range []struct {
+		address string
+		err     string
+	}{
+		{"[::1:8080", `invalid "listen-stream" address "\[::1": missing brackets`},
+		{"::1]:8080", `invalid "listen-stream" address "::1\]": missing brackets`},
+		{"[127.0.0.1]:8080", `invalid "listen-stream" address "\[127.0.0.1\]": "127.0.0.1" is not an IPv6 address`},
+		{"[fe80::zz]:8080", `invalid "listen-stream" address "\[fe80::zz\]": "fe80::zz" is not an IPv6 address`},
+		{"[fe80::1%]:8080", `invalid "listen-stream" address "\[fe80::1%\]": invalid zone ""`},
+		{"[fe80::1%e th0]:8080", `invalid "listen-stream" address "\[fe80::1%e th0\]": invalid zone "e th0"`},
+	} {
+		app.Sockets["sock"].ListenStream = t.address
+		err := ValidateApp(app)
+		c.Check(err, ErrorMatches, `invalid definition of socket "sock": `+t.err, Commentf(t.address))
+	}
+}
+
+func (s *ValidateSuite) TestValidateAppSocketsExtraBindHosts(c *C) {
+	old := ExtraSocketBindHosts
+	defer func() { ExtraSocketBindHosts = old }()
+	ExtraSocketBindHosts = []string{"[fd00::1]", "[fe80::1%eth0]", "192.168.1.10"}
+
+	app := createSampleApp()
+	socket := app.Sockets["sock"]
+	for _, address := range []string{
+		"[fd00::1]:8080",
+		"[fd00:0::1]:8080",
+		"[fe80::1%eth0]:8080",
+		"192.168.1.10:8080",
+		"127.0.0.1:8080",
+	} {
+		socket.ListenStream = address
+		c.Check(ValidateApp(app), IsNil, Commentf(address))
+	}
+
+	for _, address := range []string{
+		"[fd00::2]:8080",
+		"[fe80::1%eth1]:8080",
+		"[fe80::1]:8080",
+	} {
+		socket.ListenStream = address
+		err := ValidateApp(app)
+		c.Check(err, ErrorMatches, `invalid definition of socket "sock": invalid "listen-stream" address ".*", must be one of: 127\.0\.0\.1, \[::1\], \[::\], \[fd00::1\], \[fe80::1%eth0\], 192\.168\.1\.10`, Commentf(address))
+	}
+}
+
 func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamPort(c *C) {
 	app := createSampleApp()
 	invalidPorts := []string{
@@ -331,16 +844,66 @@ func (s *ValidateSuite) TestValidateAppSocketsInvalidListenStreamPort(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestValidateAppSocketsValidListenDatagramAddresses(c *C) {
+	app := createSampleApp()
+	validListenAddresses := []string{
+		"$SNAP_DATA/my.socket",
+		"$SNAP_COMMON/my.socket",
+		"$XDG_RUNTIME_DIR/my.socket",
+		"@snap.mysnap.my.socket",
+		"53",
+		"127.0.0.1:5353",
+		"[::]:5353",
+		"[::1]:5353",
+	}
+	socket := app.Sockets["sock"]
+	socket.ListenStream = ""
+	for _, validAddress := range validListenAddresses {
+		socket.ListenDatagram = validAddress
+		err := ValidateApp(app)
+		c.Check(err, IsNil, Commentf(validAddress))
+	}
+}
+
+func (s *ValidateSuite) TestValidateAppSocketsListenStreamAndDatagram(c *C) {
+	app := createSampleApp()
+	app.Sockets["sock"].ListenDatagram = "127.0.0.1:5353"
+	c.Check(ValidateApp(app), IsNil)
+
+	app.Sockets["sock"].ListenStream = "10.0.1.1:8080"
+	err := ValidateApp(app)
+	c.Assert(err, ErrorMatches, `invalid definition of socket "sock": invalid "listen-stream" address .*`)
+}
+
+func (s *ValidateSuite) TestValidateAppSocketsInvalidListenDatagram(c *C) {
+	app := createSampleApp()
+	socket := app.Sockets["sock"]
+	socket.ListenStream = ""
+	for _, t := range []struct {
+		address string
+		err     string
+	}{
+		{"/some/path/my.socket", `invalid "listen-datagram": daemon sockets must have a prefix of .*`},
+		{"@snap.notmysnap.my.socket", `path for "listen-datagram" must be prefixed with.*`},
+		{"10.0.1.1:5353", `invalid "listen-datagram" address "10.0.1.1", must be one of: .*`},
+		{"[::]:66536", `invalid "listen-datagram" port number.*`},
+	} {
+		socket.ListenDatagram = t.address
+		err := ValidateApp(app)
+		c.Check(err, ErrorMatches, `invalid definition of socket "sock": `+t.err, Commentf(t.address))
+	}
+}
+
 func (s *ValidateSuite) TestAppWhitelistSimple(c *C) {
 	c.Check(ValidateApp(&AppInfo{Name: "foo", Command: "foo"}), IsNil)
 	c.Check(ValidateApp(&AppInfo{Name: "foo", StopCommand: "foo"}), IsNil)
-	c.Check(ValidateApp(&AppInfo{Name: "foo", PostStopCommand: "foo"}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", PostStopCommand: "foo"}), IsNil)
 }
 
 func (s *ValidateSuite) TestAppWhitelistWithVars(c *C) {
 	c.Check(ValidateApp(&AppInfo{Name: "foo", Command: "foo $SNAP_DATA"}), IsNil)
 	c.Check(ValidateApp(&AppInfo{Name: "foo", StopCommand: "foo $SNAP_DATA"}), IsNil)
-	c.Check(ValidateApp(&AppInfo{Name: "foo", PostStopCommand: "foo $SNAP_DATA"}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", PostStopCommand: "foo $SNAP_DATA"}), IsNil)
 }
 
 func (s *ValidateSuite) TestAppWhitelistIllegal(c *C) {
@@ -355,6 +918,77 @@ func (s *ValidateSuite) TestAppWhitelistIllegal(c *C) {
 	c.Check(ValidateApp(&AppInfo{Name: "foo", CommandChain: []string{"bar baz"}}), NotNil)
 }
 
+func (s *ValidateSuite) TestAppCompleter(c *C) {
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Completer: "lib/foo-completion.bash"}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Completer: "./comp/../foo.sh"}), IsNil)
+
+	for _, tc := range []struct {
+		completer string
+		err       string
+	}{
+		{"comp/foo bar", `app description field 'completer' contains illegal "comp/foo bar" .*`},
+		{"comp/foo'bar", `app description field 'completer' contains illegal "comp/foo'bar" .*`},
+		{"/comp/foo", `completer "/comp/foo" must be a path relative to the snap`},
+		{"../foo", `completer "../foo" must point to a file inside the snap`},
+		{"comp/../../foo", `completer "comp/../../foo" must point to a file inside the snap`},
+		{"..", `completer ".." must point to a file inside the snap`},
+		{"comp/..", `completer "comp/.." must point to a file inside the snap`},
+	} {
+		err := ValidateApp(&AppInfo{Name: "foo", Completer: tc.completer})
+		c.Check(err, ErrorMatches, tc.err, Commentf(tc.completer))
+	}
+
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  bar:
+    command: bin/bar
+    completer: ../bar.sh
+`))
+	c.Assert(err, IsNil)
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `invalid definition of application "bar": completer "../bar.sh" must point to a file inside the snap`)
+}
+
+func (s *ValidateSuite) TestAppCommandChainEmptyEntry(c *C) {
+	c.Check(ValidateApp(&AppInfo{Name: "foo", CommandChain: []string{""}}), ErrorMatches, `command-chain entries cannot be empty`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", CommandChain: []string{"bin/wrapper", ""}}), ErrorMatches, `command-chain entries cannot be empty`)
+}
+
+func (s *ValidateSuite) TestAppCommandChainDuplicates(c *C) {
+	c.Check(ValidateApp(&AppInfo{Name: "foo", CommandChain: []string{"bin/wrapper", "bin/other"}}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", CommandChain: []string{"bin/wrapper", "bin/other", "bin/wrapper"}}), ErrorMatches, `command-chain contains "bin/wrapper" more than once`)
+	// the entries are still checked one by one
+	c.Check(ValidateApp(&AppInfo{Name: "foo", CommandChain: []string{"bin/wrapper", "bin/in valid"}}), ErrorMatches, `app description field 'command-chain' contains illegal .*`)
+}
+
+func (s *ValidateSuite) TestAppCommandArgs(c *C) {
+	for _, cmd := range []string{
+		"foo",
+		"bin/foo --flag a b",
+		"bin/foo  two-spaces",
+		"bin/foo $SNAP_DATA/foo.conf --user $USER",
+		"bin/foo $SNAP_DATA$SUFFIX",
+	} {
+		c.Check(ValidateApp(&AppInfo{Name: "foo", Command: cmd}), IsNil, Commentf(cmd))
+	}
+
+	for _, t := range []struct {
+		field string
+		app   *AppInfo
+		err   string
+	}{
+		{"command", &AppInfo{Name: "foo", Command: " bin/foo"}, `app description field 'command' " bin/foo" cannot start with a space`},
+		{"stop-command", &AppInfo{Name: "foo", Daemon: "simple", StopCommand: "$SNAP/bin/stop"}, `app description field 'stop-command' "\$SNAP/bin/stop" cannot use variables in the executable, they are only expanded in arguments \(use a command-chain wrapper script for anything else\)`},
+		{"reload-command", &AppInfo{Name: "foo", Daemon: "simple", ReloadCommand: "bin/reload $1"}, `app description field 'reload-command' "bin/reload \$1" has argument "\$1" with a "\$" that does not start a variable name \(use a command-chain wrapper script for anything else\)`},
+		{"post-stop-command", &AppInfo{Name: "foo", Daemon: "simple", PostStopCommand: "bin/foo --price 10$"}, `app description field 'post-stop-command' .* has argument "10\$" with a "\$" .*`},
+		{"command", &AppInfo{Name: "foo", Command: "bin/foo $$"}, `app description field 'command' .* has argument "\$\$" with a "\$" .*`},
+		{"command", &AppInfo{Name: "foo", Command: "bin/foo $-x"}, `app description field 'command' .* has argument "\$-x" with a "\$" .*`},
+	} {
+		c.Check(ValidateApp(t.app), ErrorMatches, t.err, Commentf(t.field))
+	}
+}
+
 func (s *ValidateSuite) TestAppDaemonValue(c *C) {
 	for _, t := range []struct {
 		daemon string
@@ -371,13 +1005,118 @@ func (s *ValidateSuite) TestAppDaemonValue(c *C) {
 		{"invalid-thing", false},
 	} {
 		if t.ok {
-			c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: t.daemon}), IsNil)
+			c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: t.daemon, BusName: "org.example.foo"}), IsNil)
 		} else {
 			c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: t.daemon}), ErrorMatches, fmt.Sprintf(`"daemon" field contains invalid value %q`, t.daemon))
 		}
 	}
 }
 
+func (s *ValidateSuite) TestValidateBusName(c *C) {
+	validNames := []string{
+		"org.example.foo", "a.b", "_a._b", "org.example-foo.bar_baz", "a1.b2",
+		"a." + strings.Repeat("b", 253),
+	}
+	for _, name := range validNames {
+		c.Check(ValidateBusName(name), IsNil, Commentf(name))
+	}
+	invalidNames := []string{
+		"", "foo", ".foo", "foo.", "foo..bar", "1foo.bar", "foo.1bar",
+		"-foo.bar", "foo.-bar", ":1.42", "foo.bar!", "foo.b ar",
+	}
+	for _, name := range invalidNames {
+		c.Check(ValidateBusName(name), ErrorMatches, `invalid bus name: .*`, Commentf(name))
+	}
+	c.Check(ValidateBusName("a."+strings.Repeat("b", 254)), ErrorMatches, `bus name ".*" is too long \(must be <= 255\)`)
+}
+
+func (s *ValidateSuite) TestAppBusName(c *C) {
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "dbus"}), ErrorMatches, `"bus-name" must be set for "dbus" daemons`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "dbus", BusName: "foo"}), ErrorMatches, `invalid bus name: "foo"`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "dbus", BusName: "org.example.foo"}), IsNil)
+	// bus-name is optional for other apps
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple"}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", BusName: "foo"}), ErrorMatches, `invalid bus name: "foo"`)
+}
+
+func (s *ValidateSuite) TestAppActivatesOn(c *C) {
+	const yaml = `name: foo
+version: 1.0
+slots:
+  dbus-slot:
+    interface: dbus
+    bus: session
+    name: org.example.foo
+  other-slot:
+    interface: content
+apps:
+  foo:
+    command: foo
+    daemon: %s
+    bus-name: org.example.foo
+    activates-on: [%s]
+`
+	for _, t := range []struct {
+		daemon, slot string
+		err          string
+	}{
+		{"dbus", "dbus-slot", ""},
+		{"dbus", "other-slot", `invalid definition of application "foo": invalid activates-on value "other-slot": slot does not use the dbus interface`},
+		{"simple", "dbus-slot", `invalid definition of application "foo": invalid activates-on value "dbus-slot": only applicable to "dbus" daemons`},
+	} {
+		info, err := InfoFromSnapYaml([]byte(fmt.Sprintf(yaml, t.daemon, t.slot)))
+		c.Assert(err, IsNil)
+		err = Validate(info)
+		if t.err == "" {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, ErrorMatches, t.err)
+		}
+	}
+
+	// slots of other snaps are rejected
+	info, err := InfoFromSnapYaml([]byte(fmt.Sprintf(yaml, "dbus", "dbus-slot")))
+	c.Assert(err, IsNil)
+	info.Apps["foo"].ActivatesOn = []*SlotInfo{{Snap: &Info{}, Name: "dbus-slot", Interface: "dbus"}}
+	c.Check(Validate(info), ErrorMatches, `invalid definition of application "foo": invalid activates-on value "dbus-slot": slot is not defined by the snap`)
+}
+
+func (s *ValidateSuite) TestAppEnvironment(c *C) {
+	for _, name := range []string{"FOO", "_FOO", "foo_bar", "A1", "_"} {
+		app := &AppInfo{Name: "foo", Environment: *strutil.NewOrderedMap(name, "value")}
+		c.Check(ValidateApp(app), IsNil, Commentf(name))
+	}
+	for _, name := range []string{"", "1FOO", "FOO-BAR", "FOO BAR", "FOO=BAR", "FOO.BAR", "FÖO"} {
+		app := &AppInfo{Name: "foo", Environment: *strutil.NewOrderedMap(name, "value")}
+		c.Check(ValidateApp(app), ErrorMatches, fmt.Sprintf("invalid environment variable name %q", name), Commentf(name))
+	}
+
+	app := &AppInfo{Name: "foo", Environment: *strutil.NewOrderedMap("FOO", "a\x00b")}
+	c.Check(ValidateApp(app), ErrorMatches, `environment variable "FOO" cannot contain NUL bytes`)
+}
+
+func (s *ValidateSuite) TestSnapEnvironment(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+environment:
+  FOO: bar
+  not-valid: baz
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `invalid environment variable name "not-valid"`)
+
+	info, err = InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    command: foo
+    environment:
+      FOO BAR: baz
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `invalid definition of application "foo": invalid environment variable name "FOO BAR"`)
+}
+
 func (s *ValidateSuite) TestAppStopMode(c *C) {
 	// check services
 	for _, t := range []struct {
@@ -409,6 +1148,39 @@ func (s *ValidateSuite) TestAppStopMode(c *C) {
 	c.Check(err, ErrorMatches, `"stop-mode" cannot be used for "foo", only for services`)
 }
 
+func (s *ValidateSuite) TestAppPostStopCommand(c *C) {
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", PostStopCommand: "bin/post-stop"}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "oneshot", PostStopCommand: "bin/post-stop"}), IsNil)
+
+	// non-services cannot have a post-stop-command
+	err := ValidateApp(&AppInfo{Name: "foo", Daemon: "", PostStopCommand: "bin/post-stop"})
+	c.Check(err, ErrorMatches, `"post-stop-command" cannot be used for "foo", only for services`)
+}
+
+func (s *ValidateSuite) TestAppReloadCommand(c *C) {
+	for _, t := range []struct {
+		daemon string
+		ok     bool
+	}{
+		{"simple", true},
+		{"forking", true},
+		{"notify", true},
+		{"dbus", true},
+		{"oneshot", false},
+		{"", false},
+	} {
+		app := &AppInfo{Name: "foo", Daemon: t.daemon, ReloadCommand: "bin/reload"}
+		if t.daemon == "dbus" {
+			app.BusName = "org.example.foo"
+		}
+		if t.ok {
+			c.Check(ValidateApp(app), IsNil, Commentf(t.daemon))
+		} else {
+			c.Check(ValidateApp(app), ErrorMatches, `"reload-command" cannot be used for "foo", only for long-running services \(simple, forking, notify or dbus\)`, Commentf(t.daemon))
+		}
+	}
+}
+
 func (s *ValidateSuite) TestAppRefreshMode(c *C) {
 	// check services
 	for _, t := range []struct {
@@ -432,6 +1204,38 @@ func (s *ValidateSuite) TestAppRefreshMode(c *C) {
 	// non-services cannot have a refresh-mode
 	err := ValidateApp(&AppInfo{Name: "foo", Daemon: "", RefreshMode: "endure"})
 	c.Check(err, ErrorMatches, `"refresh-mode" cannot be used for "foo", only for services`)
+
+	// enduring services cannot always be restarted
+	err = ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", RefreshMode: "endure", RestartCond: RestartAlways})
+	c.Check(err, ErrorMatches, `"refresh-mode: endure" cannot be combined with "restart-condition: always" for "foo"`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", RefreshMode: "endure", RestartCond: RestartOnFailure}), IsNil)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", RefreshMode: "restart", RestartCond: RestartAlways}), IsNil)
+}
+
+func (s *ValidateSuite) TestAppInstallMode(c *C) {
+	// check services
+	for _, t := range []struct {
+		installMode string
+		ok          bool
+	}{
+		// good
+		{"", true},
+		{"enable", true},
+		{"disable", true},
+		// bad
+		{"disabled-x", false},
+		{"enabled", false},
+	} {
+		if t.ok {
+			c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", InstallMode: t.installMode}), IsNil)
+		} else {
+			c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", InstallMode: t.installMode}), ErrorMatches, fmt.Sprintf(`"install-mode" field contains invalid value %q`, t.installMode))
+		}
+	}
+
+	// non-services cannot have an install-mode
+	err := ValidateApp(&AppInfo{Name: "foo", Daemon: "", InstallMode: "disable"})
+	c.Check(err, ErrorMatches, `"install-mode" cannot be used for "foo", only for services`)
 }
 
 func (s *ValidateSuite) TestAppWhitelistError(c *C) {
@@ -444,49 +1248,264 @@ func (s *ValidateSuite) TestAppWhitelistError(c *C) {
 
 func (s *ValidateSuite) TestDetectIllegalYamlBinaries(c *C) {
 	info, err := InfoFromSnapYaml([]byte(`name: foo
-version: 1.0
-apps:
- tes!me:
-   command: someething
+version: 1.0
+apps:
+ tes!me:
+   command: someething
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, NotNil)
+}
+
+func (s *ValidateSuite) TestDetectIllegalYamlService(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+ tes!me:
+   command: something
+   daemon: forking
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, NotNil)
+}
+
+func (s *ValidateSuite) TestIllegalSnapName(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo.something
+version: 1.0
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `invalid snap name: "foo.something"`)
+}
+
+func (s *ValidateSuite) TestValidateChecksName(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`
+version: 1.0
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `snap name cannot be empty`)
+}
+
+func (s *ValidateSuite) TestValidateAllCollectsErrors(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: ~1.0
+license: GPL~3.0
+apps:
+  foo:
+    command: foo
+    daemon: bad
+  bar:
+    command: bar'
+hooks:
+  configure:
+    command-chain: [in'valid]
+`))
+	c.Assert(err, IsNil)
+
+	errs := ValidateAll(info)
+	c.Assert(errs, HasLen, 5)
+	c.Check(errs[0], ErrorMatches, `invalid snap version "~1.0": .*`)
+	c.Check(errs[1], ErrorMatches, `cannot validate license "GPL~3.0": .*`)
+	c.Check(errs[2], ErrorMatches, `invalid definition of application "bar": app description field 'command' contains illegal .*`)
+	c.Check(errs[3], ErrorMatches, `invalid definition of application "foo": "daemon" field contains invalid value "bad"`)
+	c.Check(errs[4], ErrorMatches, `hook command-chain contains illegal .*`)
+
+	// Validate returns the first of them
+	c.Check(Validate(info), DeepEquals, errs[0])
+}
+
+func (s *ValidateSuite) TestValidateAllHappy(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    command: foo
+`))
+	c.Assert(err, IsNil)
+	c.Check(ValidateAll(info), HasLen, 0)
+}
+
+func (s *ValidateSuite) TestValidateWithWarnings(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+title: ` + strings.Repeat("t", 38) + `
+description: ` + strings.Repeat("d", 4000) + `
+layout:
+  /usr/lib:
+    bind: $SNAP/usr/lib
+  /etc/foo.conf:
+    bind-file: $SNAP/foo.conf
+`))
+	c.Assert(err, IsNil)
+
+	warnings, err := ValidateWithWarnings(info)
+	// warnings are not errors
+	c.Check(err, IsNil)
+	c.Check(warnings, DeepEquals, []string{
+		`title is close to the limit of 40 codepoints, got 38`,
+		`description is close to the limit of 4096 codepoints, got 4000`,
+		`layout "/usr/lib" shadows the entire /usr/lib directory of the base snap`,
+	})
+}
+
+func (s *ValidateSuite) TestValidateWithWarningsAnyVersion(c *C) {
+	// snapd compares any valid version, semantic or not
+	for _, version := range []string{"1", "1.0", "1.0.0", "2019.10-rc1", "v1~beta"} {
+		info, err := InfoFromSnapYaml([]byte("name: foo\nversion: " + version + "\n"))
+		c.Assert(err, IsNil)
+		warnings, err := ValidateWithWarnings(info)
+		c.Check(err, IsNil, Commentf(version))
+		c.Check(warnings, HasLen, 0, Commentf(version))
+	}
+}
+
+func (s *ValidateSuite) TestValidateWithWarningsHardErrorsUnchanged(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0.0
+description: ` + strings.Repeat("d", 4097) + `
+`))
+	c.Assert(err, IsNil)
+
+	warnings, err := ValidateWithWarnings(info)
+	c.Check(err, ErrorMatches, `description can have up to 4096 codepoints, got 4097`)
+	c.Check(warnings, HasLen, 0)
+
+	info.OriginalDescription = "short"
+	warnings, err = ValidateWithWarnings(info)
+	c.Check(err, IsNil)
+	c.Check(warnings, HasLen, 0)
+}
+
+func (s *ValidateSuite) TestValidateWithConfigDefault(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0.0
+title: ` + strings.Repeat("t", 41) + `
+description: ` + strings.Repeat("d", 4097) + `
 `))
 	c.Assert(err, IsNil)
 
-	err = Validate(info)
-	c.Check(err, NotNil)
+	errs, warnings := ValidateWithConfig(info, ValidationConfig{})
+	c.Check(errs, DeepEquals, ValidateAll(info))
+	c.Check(errs, HasLen, 2)
+	c.Check(warnings, HasLen, 0)
 }
 
-func (s *ValidateSuite) TestDetectIllegalYamlService(c *C) {
+func (s *ValidateSuite) TestValidateWithConfigSeverities(c *C) {
 	info, err := InfoFromSnapYaml([]byte(`name: foo
-version: 1.0
-apps:
- tes!me:
-   command: something
-   daemon: forking
+version: 1.0.0
+title: ` + strings.Repeat("t", 41) + `
+description: ` + strings.Repeat("d", 4097) + `
+license: GPL-3.0 AND
 `))
 	c.Assert(err, IsNil)
 
-	err = Validate(info)
-	c.Check(err, NotNil)
+	errs, warnings := ValidateWithConfig(info, ValidationConfig{
+		Severities: map[ValidationCheck]Severity{
+			CheckTitle:       SeverityWarning,
+			CheckDescription: SeverityOff,
+			CheckLicense:     SeverityError,
+		},
+	})
+	c.Assert(errs, HasLen, 1)
+	c.Check(errs[0], ErrorMatches, `cannot validate license "GPL-3.0 AND": .*`)
+	c.Check(warnings, DeepEquals, []string{
+		`title can have up to 40 codepoints, got 41`,
+	})
 }
 
-func (s *ValidateSuite) TestIllegalSnapName(c *C) {
-	info, err := InfoFromSnapYaml([]byte(`name: foo.something
+func (s *ValidateSuite) TestValidateWithConfigKeepsWarnings(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
 version: 1.0
+title: ` + strings.Repeat("t", 38) + `
 `))
 	c.Assert(err, IsNil)
 
-	err = Validate(info)
-	c.Check(err, ErrorMatches, `invalid snap name: "foo.something"`)
+	errs, warnings := ValidateWithConfig(info, ValidationConfig{})
+	c.Check(errs, HasLen, 0)
+	expected, err := ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, DeepEquals, expected)
 }
 
-func (s *ValidateSuite) TestValidateChecksName(c *C) {
-	info, err := InfoFromSnapYaml([]byte(`
+func (s *ValidateSuite) TestValidateSocketAddressConflicts(c *C) {
+	const yaml = `name: foo
+version: 1.0
+apps:
+  foo:
+    command: foo
+    daemon: simple
+    plugs: [network-bind]
+    sockets:
+      sock1:
+        %s
+  bar:
+    command: bar
+    daemon: simple
+    plugs: [network-bind]
+    sockets:
+      sock2:
+        %s
+`
+	for _, t := range []struct {
+		foo, bar string
+		err      string
+	}{
+		{"listen-stream: $SNAP_DATA/sock", "listen-stream: $SNAP_DATA/sock", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "\$SNAP_DATA/sock"`},
+		{"listen-stream: $SNAP_DATA/sock", "listen-datagram: $SNAP_DATA/sock", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "\$SNAP_DATA/sock"`},
+		{"listen-stream: '@snap.foo.sock'", "listen-datagram: '@snap.foo.sock'", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "@snap.foo.sock"`},
+		{"listen-stream: 8080", "listen-stream: '[::]:8080'", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "8080"`},
+		{"listen-stream: '[::0]:8080'", "listen-stream: '[::]:08080'", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "\[::0\]:8080"`},
+		{"listen-stream: 127.0.0.1:53", "listen-stream: 127.0.0.1:53", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "127.0.0.1:53"`},
+		// wildcard addresses conflict with any host on the same port
+		{"listen-stream: 8080", "listen-stream: 127.0.0.1:8080", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "8080"`},
+		{"listen-stream: '[::1]:8080'", "listen-stream: '[::]:8080'", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "\[::1\]:8080"`},
+		{"listen-datagram: 127.0.0.1:53", "listen-datagram: 53", `sockets "bar.sock2" and "foo.sock1" cannot both listen on "127.0.0.1:53"`},
+		// different namespaces or addresses do not conflict
+		{"listen-stream: $SNAP_DATA/sock", "listen-stream: $SNAP_COMMON/sock", ""},
+		{"listen-stream: 53", "listen-datagram: 53", ""},
+		{"listen-stream: 127.0.0.1:53", "listen-stream: '[::1]:53'", ""},
+		{"listen-stream: 8080", "listen-datagram: 127.0.0.1:8080", ""},
+		{"listen-stream: 8080", "listen-stream: 127.0.0.1:8081", ""},
+	} {
+		info, err := InfoFromSnapYaml([]byte(fmt.Sprintf(yaml, t.foo, t.bar)))
+		c.Assert(err, IsNil)
+		err = Validate(info)
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf("%s / %s", t.foo, t.bar))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf("%s / %s", t.foo, t.bar))
+		}
+	}
+}
+
+func (s *ValidateSuite) TestSocketAddressKeyExpandsPaths(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
 version: 1.0
+apps:
+  foo:
+    daemon: simple
 `))
 	c.Assert(err, IsNil)
-
-	err = Validate(info)
-	c.Check(err, ErrorMatches, `snap name cannot be empty`)
+	info.InstanceKey = "bar"
+	info.Revision = R(42)
+	socket := &SocketInfo{App: info.Apps["foo"], Name: "sock"}
+
+	for _, t := range []struct{ address, key string }{
+		{"$SNAP_DATA/sock", "path:" + dirs.SnapDataDir + "/foo_bar/42/sock"},
+		{"$SNAP_COMMON/sock", "path:" + dirs.SnapDataDir + "/foo_bar/common/sock"},
+		{"$XDG_RUNTIME_DIR/sock", "path:" + dirs.XdgRuntimeDirBase + "/0/snap.foo_bar/sock"},
+		{"@snap.foo.sock", "abstract:@snap.foo.sock"},
+		{"8080", "tcp:[::]:8080"},
+	} {
+		c.Check(SocketAddressKey(socket, "listen-stream", t.address), Equals, t.key, Commentf(t.address))
+	}
 }
 
 func (s *ValidateSuite) TestIllegalSnapEpoch(c *C) {
@@ -497,6 +1516,23 @@ epoch: 0*
 	c.Assert(err, ErrorMatches, `.*invalid epoch.*`)
 }
 
+func (s *ValidateSuite) TestSnapEpochWriteNotReadable(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+epoch:
+  read: [1, 2]
+  write: [2, 3]
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `epoch cannot write 3 without reading it \(read: \[1, 2\], write: \[2, 3\]\)`)
+
+	for _, epoch := range []string{"0", "1", "2*", "{read: [1, 2, 3], write: [1, 3]}"} {
+		info, err := InfoFromSnapYaml([]byte("name: foo\nversion: 1.0\nepoch: " + epoch + "\n"))
+		c.Assert(err, IsNil)
+		c.Check(Validate(info), IsNil, Commentf(epoch))
+	}
+}
+
 func (s *ValidateSuite) TestMissingSnapEpochIsOkay(c *C) {
 	info, err := InfoFromSnapYaml([]byte(`name: foo
 version: 1.0
@@ -513,7 +1549,7 @@ license: GPL~3.0
 	c.Assert(err, IsNil)
 
 	err = Validate(info)
-	c.Check(err, ErrorMatches, `cannot validate license "GPL~3.0": unknown license: GPL~3.0`)
+	c.Check(err, ErrorMatches, `cannot validate license "GPL~3.0": unknown license: GPL~3.0 \(at "GPL~3.0", offset 0\)`)
 }
 
 func (s *ValidateSuite) TestMissingSnapLicenseIsOkay(c *C) {
@@ -566,6 +1602,181 @@ apps:
 	c.Check(err, ErrorMatches, `cannot have "foo\$" as alias name for app "foo" - use only letters, digits, dash, underscore and dot characters`)
 }
 
+func (s *ValidateSuite) TestAliasCollidesWithApp(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    aliases: [bar]
+  bar:
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `cannot set "bar" as alias for "foo", it is the name of app "bar"`)
+
+	// an alias can use the name of its own app
+	info, err = InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    aliases: [foo]
+  bar:
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), IsNil)
+}
+
+func (s *ValidateSuite) TestAliasUsedByTwoApps(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    aliases: [baz]
+  bar:
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), IsNil)
+
+	// the yaml parser refuses this, but Info can be built by other means
+	info.Apps["bar"].LegacyAliases = []string{"baz"}
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `cannot set "baz" as alias for both "bar" and "foo"`)
+}
+
+func (s *ValidateSuite) TestValidateApps(c *C) {
+	// the top-level fields are not checked
+	info := &Info{Apps: map[string]*AppInfo{}}
+	info.Apps["foo"] = &AppInfo{Snap: info, Name: "foo", Command: "bin/foo", Daemon: "simple", After: []string{"bar"}}
+	info.Apps["bar"] = &AppInfo{Snap: info, Name: "bar", Command: "bin/bar", Daemon: "simple"}
+	c.Check(ValidateApps(info), HasLen, 0)
+	c.Check(Validate(info), ErrorMatches, `snap name cannot be empty`)
+
+	info.Apps["bar"].After = []string{"foo"}
+	errs := ValidateApps(info)
+	c.Assert(errs, HasLen, 1)
+	c.Check(errs[0], ErrorMatches, `applications are part of a before/after cycle: .*`)
+
+	info.Apps["bar"].After = nil
+	info.Apps["bar"].CommonID = "org.example.foo"
+	info.Apps["foo"].CommonID = "org.example.foo"
+	info.Apps["baz"] = &AppInfo{Snap: info, Name: "baz", Command: "bin/baz", Daemon: "forever"}
+	info.Apps["qux"] = &AppInfo{Snap: info, Name: "qux", Command: "bin/qux", Daemon: "simple", Before: []string{"quux"}}
+	errs = ValidateApps(info)
+	c.Assert(errs, HasLen, 3)
+	c.Check(errs[0], ErrorMatches, `invalid definition of application "baz": "daemon" field contains invalid value "forever"`)
+	c.Check(errs[1], ErrorMatches, `invalid definition of application "qux": before/after references a missing application "quux"`)
+	c.Check(errs[2], ErrorMatches, `application "(foo|bar)" common-id "org.example.foo" must be unique, already used by application "(foo|bar)"`)
+}
+
+func (s *ValidateSuite) TestValidateYaml(c *C) {
+	c.Check(ValidateYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    command: bin/foo
+`)), IsNil)
+
+	err := ValidateYaml([]byte(`name: foo
+version: [1
+`))
+	c.Assert(err, FitsTypeOf, YamlParseError{})
+	c.Check(err, ErrorMatches, `cannot parse snap.yaml: .*`)
+
+	err = ValidateYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    command: bin/foo
+    daemon: forever
+`))
+	c.Assert(err, FitsTypeOf, YamlValidationError{})
+	c.Check(err, ErrorMatches, `invalid definition of application "foo": "daemon" field contains invalid value "forever"`)
+
+	err = ValidateYaml([]byte(`name: foo
+version: 1.0
+license: GPL-3.0 WITH nothing
+apps:
+  foo:
+    command: bin/foo
+    daemon: forever
+`))
+	c.Assert(err, FitsTypeOf, YamlValidationError{})
+	c.Check(err.(YamlValidationError).Errs, HasLen, 2)
+	c.Check(err, ErrorMatches, `invalid snap.yaml:
+- cannot validate license .*
+- invalid definition of application "foo": .*`)
+}
+
+func (s *ValidateSuite) TestAddValidator(c *C) {
+	restore := MockValidators()
+	defer restore()
+
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), IsNil)
+
+	var seen []string
+	AddValidator(func(info *Info) error {
+		seen = append(seen, "first")
+		return nil
+	})
+	AddValidator(func(info *Info) error {
+		seen = append(seen, "second")
+		return fmt.Errorf("snap %q is not welcome", info.SnapName())
+	})
+	c.Check(Validate(info), ErrorMatches, `snap "foo" is not welcome`)
+	c.Check(seen, DeepEquals, []string{"first", "second"})
+
+	// added validators run after the built-in ones
+	info.Version = ""
+	errs := ValidateAll(info)
+	c.Assert(errs, HasLen, 2)
+	c.Check(errs[0], ErrorMatches, `invalid snap version: cannot be empty`)
+	c.Check(errs[1], ErrorMatches, `snap "foo" is not welcome`)
+
+	// and can be reset
+	MockValidators()
+	info.Version = "1.0"
+	c.Check(Validate(info), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateAliasesAgainst(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    aliases: [foo, ls, grep]
+  bar:
+    aliases: [bar, cat]
+`))
+	c.Assert(err, IsNil)
+
+	c.Check(ValidateAliasesAgainst(info, nil), HasLen, 0)
+
+	errs := ValidateAliasesAgainst(info, map[string]string{
+		// other snaps
+		"grep": "grep-snap",
+		"ls":   "coreutils.ls",
+		// the snap itself, e.g. when refreshing
+		"foo": "foo",
+		"bar": "foo.bar",
+		// unrelated
+		"sed": "coreutils.sed",
+	})
+	c.Assert(errs, HasLen, 2)
+	c.Check(errs[0], ErrorMatches, `cannot use "grep" as alias for app "foo", it is already used for "grep-snap"`)
+	c.Check(errs[1], ErrorMatches, `cannot use "ls" as alias for app "foo", it is already used for "coreutils.ls"`)
+
+	// parallel instances are different snaps
+	info.InstanceKey = "instance"
+	errs = ValidateAliasesAgainst(info, map[string]string{"cat": "foo.bar"})
+	c.Assert(errs, HasLen, 1)
+	c.Check(errs[0], ErrorMatches, `cannot use "cat" as alias for app "bar", it is already used for "foo.bar"`)
+}
+
 func (s *ValidateSuite) TestValidatePlugSlotName(c *C) {
 	const yaml1 = `
 name: invalid-plugs
@@ -662,6 +1873,18 @@ hooks:
 	}
 }
 
+func (s *ValidateSuite) TestValidateBaseNoneLayoutsError(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: use-base-none
+version: 1
+base: none
+layout:
+  /usr/share/foo:
+    bind: $SNAP/usr/share/foo
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `cannot have layouts with base "none"`)
+}
+
 type testConstraint string
 
 func (constraint testConstraint) IsOffLimits(path string) bool {
@@ -690,9 +1913,9 @@ func (s *ValidateSuite) TestValidateLayout(c *C) {
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "ext4"}, nil),
 		ErrorMatches, `layout "/foo" uses invalid filesystem "ext4"`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo/bar", Type: "tmpfs", User: "foo"}, nil),
-		ErrorMatches, `layout "/foo/bar" uses invalid user "foo"`)
+		ErrorMatches, `layout "/foo/bar" uses invalid user "foo": must be root or declared in system-usernames`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo/bar", Type: "tmpfs", Group: "foo"}, nil),
-		ErrorMatches, `layout "/foo/bar" uses invalid group "foo"`)
+		ErrorMatches, `layout "/foo/bar" uses invalid group "foo": must be root or declared in system-usernames`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "tmpfs", Mode: 02755}, nil),
 		ErrorMatches, `layout "/foo" uses invalid mode 02755`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$FOO", Type: "tmpfs"}, nil),
@@ -701,10 +1924,29 @@ func (s *ValidateSuite) TestValidateLayout(c *C) {
 		ErrorMatches, `layout "/foo" uses invalid bind mount source "\$BAR": reference to unknown variable "\$BAR"`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$SNAP/evil", Bind: "/etc"}, nil),
 		ErrorMatches, `layout "\$SNAP/evil" uses invalid bind mount source "/etc": must start with \$SNAP, \$SNAP_DATA or \$SNAP_COMMON`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Bind: "$SNAP/foo", Options: []string{"ro"}}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", BindFile: "$SNAP/foo", Options: []string{"rw"}}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "tmpfs", Options: []string{"ro"}}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Symlink: "$SNAP/foo", Options: []string{"ro"}}, nil),
+		ErrorMatches, `layout "/foo" uses a symlink and cannot set options`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Bind: "$SNAP/foo", Options: []string{"ro", "noexec"}}, nil),
+		ErrorMatches, `layout "/foo" uses unsupported option "noexec" \(supported: ro, rw\)`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Bind: "$SNAP/foo", Options: []string{"ro", "rw"}}, nil),
+		ErrorMatches, `layout "/foo" cannot be both read-only and read-write`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$SNAP/foo", Bind: "$SNAP/foo"}, nil),
+		ErrorMatches, `layout "\$SNAP/foo" uses bind mount source "\$SNAP/foo" which is the same as the mount point \(both expand to "/snap/foo/unset/foo"\)`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/snap/foo/unset/foo.conf", BindFile: "$SNAP/foo.conf"}, nil),
+		ErrorMatches, `layout "/snap/foo/unset/foo.conf" uses bind mount source "\$SNAP/foo.conf" which is the same as the mount point \(both expand to "/snap/foo/unset/foo.conf"\)`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Symlink: "$BAR"}, nil),
 		ErrorMatches, `layout "/foo" uses invalid symlink old name "\$BAR": reference to unknown variable "\$BAR"`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$SNAP/evil", Symlink: "/etc"}, nil),
 		ErrorMatches, `layout "\$SNAP/evil" uses invalid symlink old name "/etc": must start with \$SNAP, \$SNAP_DATA or \$SNAP_COMMON`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Symlink: "$SNAP/foo", Mode: 0644}, nil),
+		ErrorMatches, `layout "/foo" uses a symlink and cannot set mode 0644`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Symlink: "$SNAP/foo", User: "daemon"}, nil),
+		ErrorMatches, `layout "/foo" uses a symlink and cannot set user "daemon"`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Symlink: "$SNAP/foo", Group: "daemon"}, nil),
+		ErrorMatches, `layout "/foo" uses a symlink and cannot set group "daemon"`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo/bar", Bind: "$SNAP/bar/foo"}, []LayoutConstraint{testConstraint("/foo")}),
 		ErrorMatches, `layout "/foo/bar" underneath prior layout item "/foo"`)
 
@@ -734,21 +1976,109 @@ func (s *ValidateSuite) TestValidateLayout(c *C) {
 		ErrorMatches, `layout "/lib/firmware" in an off-limits area`)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/lib/modules", Type: "tmpfs"}, nil),
 		ErrorMatches, `layout "/lib/modules" in an off-limits area`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$SNAP", Type: "tmpfs"}, nil),
+		ErrorMatches, `layout "\$SNAP" shadows the snap's own \$SNAP directory`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/snap/foo", Type: "tmpfs"}, nil),
+		ErrorMatches, `layout "/snap/foo" shadows the snap's own \$SNAP directory`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/snap", Bind: "$SNAP/snap"}, nil),
+		ErrorMatches, `layout "/snap" shadows the snap's own \$SNAP directory`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var", Bind: "$SNAP_DATA/var"}, nil),
+		ErrorMatches, `layout "/var" shadows the snap's own \$SNAP_DATA directory`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/", Type: "tmpfs"}, nil),
+		ErrorMatches, `layout "/" shadows the snap's own \$SNAP directory`)
+	// but they can be placed inside those directories
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$SNAP/foo", Type: "tmpfs"}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/snap/foobar", Type: "tmpfs"}, nil), IsNil)
 
 	// Several valid layouts.
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "tmpfs", Mode: 01755}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/tmp", Type: "tmpfs"}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/usr", Bind: "$SNAP/usr"}, nil), IsNil)
-	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var", Bind: "$SNAP_DATA/var"}, nil), IsNil)
-	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var", Bind: "$SNAP_COMMON/var"}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var/cache", Bind: "$SNAP_DATA/var"}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var/cache", Bind: "$SNAP_COMMON/var"}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/etc/foo.conf", Symlink: "$SNAP_DATA/etc/foo.conf"}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/a/b", Type: "tmpfs", User: "root"}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/a/b", Type: "tmpfs", Group: "root"}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/a/b", Type: "tmpfs", Mode: 0655}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/usr", Symlink: "$SNAP/usr"}, nil), IsNil)
-	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var", Symlink: "$SNAP_DATA/var"}, nil), IsNil)
-	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var", Symlink: "$SNAP_COMMON/var"}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var/cache", Symlink: "$SNAP_DATA/var"}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/var/cache", Symlink: "$SNAP_COMMON/var"}, nil), IsNil)
 	c.Check(ValidateLayout(&Layout{Snap: si, Path: "$SNAP/data", Symlink: "$SNAP_DATA"}, nil), IsNil)
+	// the defaults filled in from snap.yaml are fine for symlinks
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Symlink: "$SNAP/foo", Mode: 0755, User: "root", Group: "root"}, nil), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateSystemUsernames(c *C) {
+	for _, scope := range []string{"", "shared", "private", "external"} {
+		info := &Info{SystemUsernames: map[string]*SystemUsernameInfo{
+			"snap_daemon": {Name: "snap_daemon", Scope: scope},
+		}}
+		c.Check(ValidateSystemUsernames(info), IsNil, Commentf(scope))
+	}
+	c.Check(ValidateSystemUsernames(&Info{}), IsNil)
+
+	info := &Info{SystemUsernames: map[string]*SystemUsernameInfo{
+		"snap_daemon": {Name: "snap_daemon", Scope: "global"},
+	}}
+	c.Check(ValidateSystemUsernames(info), ErrorMatches, `system username "snap_daemon" has invalid scope "global"`)
+
+	info = &Info{SystemUsernames: map[string]*SystemUsernameInfo{
+		"snap_daemon": {Name: "snap_daemon", Scope: "shared"},
+		"daemon":      {Name: "daemon", Scope: "shared"},
+	}}
+	c.Check(ValidateSystemUsernames(info), ErrorMatches, `system username "daemon" is not supported \(supported: snap_daemon\)`)
+
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+system-usernames:
+  snap_daemon: nope
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `system username "snap_daemon" has invalid scope "nope"`)
+}
+
+func (s *ValidateSuite) TestValidateLayoutSystemUsernames(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+system-usernames:
+  snap_daemon: shared
+layout:
+  /var/lib/foo:
+    type: tmpfs
+    user: snap_daemon
+    group: snap_daemon
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.SystemUsernames, HasLen, 1)
+	c.Check(Validate(info), IsNil)
+
+	si := info
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "tmpfs", User: "snap_other"}, nil),
+		ErrorMatches, `layout "/foo" uses invalid user "snap_other": must be root or declared in system-usernames`)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "tmpfs", Group: "snap_other"}, nil),
+		ErrorMatches, `layout "/foo" uses invalid group "snap_other": must be root or declared in system-usernames`)
+	for _, name := range []string{"Snap_daemon", "snap daemon", "1snap", "snap:daemon", strings.Repeat("a", 33)} {
+		c.Check(ValidateLayout(&Layout{Snap: si, Path: "/foo", Type: "tmpfs", User: name}, nil),
+			ErrorMatches, fmt.Sprintf(`layout "/foo" uses invalid user %q`, name))
+	}
+}
+
+func (s *ValidateSuite) TestValidateLayoutWith(c *C) {
+	si := &Info{SuggestedName: "foo"}
+	offLimits := append([]string{"/opt/vendor"}, OffLimitsLayoutPaths...)
+
+	c.Check(ValidateLayoutWith(&Layout{Snap: si, Path: "/opt/vendor/foo", Type: "tmpfs"}, nil, offLimits),
+		ErrorMatches, `layout "/opt/vendor/foo" in an off-limits area`)
+	c.Check(ValidateLayoutWith(&Layout{Snap: si, Path: "/dev", Type: "tmpfs"}, nil, offLimits),
+		ErrorMatches, `layout "/dev" in an off-limits area`)
+	c.Check(ValidateLayoutWith(&Layout{Snap: si, Path: "/opt/other", Type: "tmpfs"}, nil, offLimits), IsNil)
+	// relaxed list
+	c.Check(ValidateLayoutWith(&Layout{Snap: si, Path: "/media/foo", Type: "tmpfs"}, nil, []string{"/proc"}), IsNil)
+
+	// the defaults are not affected
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/opt/vendor/foo", Type: "tmpfs"}, nil), IsNil)
+	c.Check(ValidateLayout(&Layout{Snap: si, Path: "/media/foo", Type: "tmpfs"}, nil),
+		ErrorMatches, `layout "/media/foo" in an off-limits area`)
 }
 
 func (s *ValidateSuite) TestValidateLayoutAll(c *C) {
@@ -945,43 +2275,211 @@ layout:
 	strk = NewScopedTracker()
 	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml9), &SideInfo{Revision: R(42)}, strk)
 	c.Assert(err, IsNil)
-	c.Assert(info.Layout, HasLen, 2)
+	c.Assert(info.Layout, HasLen, 2)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, ErrorMatches, `layout "/etc/norf" refers to file "\$SNAP/etc/norf" but another layout treats it as a directory`)
+
+	// Same source path referred from a bind mount and symlink doesn't clash.
+	const yaml10 = `
+name: non-clashing-source-1
+layout:
+  /etc/norf:
+    bind: $SNAP/etc/norf
+  /etc/corge:
+    symlink: $SNAP/etc/norf
+`
+
+	strk = NewScopedTracker()
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml10), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	c.Assert(info.Layout, HasLen, 2)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, IsNil)
+
+	// Same source path referred from a file bind mount and symlink doesn't clash.
+	const yaml11 = `
+name: non-clashing-source-1
+layout:
+  /etc/norf:
+    bind-file: $SNAP/etc/norf
+  /etc/corge:
+    symlink: $SNAP/etc/norf
+`
+
+	strk = NewScopedTracker()
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml11), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	c.Assert(info.Layout, HasLen, 2)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, IsNil)
+}
+
+func (s *ValidateSuite) TestValidateLayoutAllLimits(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+layout:
+  /usr/share/foo:
+    bind: $SNAP/usr/share/foo
+  /var/lib/foo:
+    bind: $SNAP_DATA/foo
+`))
+	c.Assert(err, IsNil)
+	c.Check(ValidateLayoutAll(info), IsNil)
+
+	oldMaxLayouts := MaxLayouts
+	defer func() { MaxLayouts = oldMaxLayouts }()
+	MaxLayouts = 1
+	c.Check(ValidateLayoutAll(info), ErrorMatches, `snap has 2 layouts, at most 1 are allowed`)
+	MaxLayouts = oldMaxLayouts
+
+	oldMaxLayoutDepth := MaxLayoutDepth
+	defer func() { MaxLayoutDepth = oldMaxLayoutDepth }()
+	MaxLayoutDepth = 2
+	c.Check(ValidateLayoutAll(info), ErrorMatches, `layout "/usr/share/foo" is nested too deeply \(3 levels, max 2\)`)
+
+	// the depth is counted once variables are expanded
+	MaxLayoutDepth = 4
+	info.Layout = map[string]*Layout{
+		"$SNAP/foo/bar": {Snap: info, Path: "$SNAP/foo/bar", Type: "tmpfs"},
+	}
+	c.Check(ValidateLayoutAll(info), ErrorMatches, `layout "\$SNAP/foo/bar" is nested too deeply \(5 levels, max 4\)`)
+}
+
+func (s *ValidateSuite) TestValidateLayoutAllTmpfsShadowing(c *C) {
+	// The bind mount sorts before the tmpfs above it.
+	const yaml1 = `
+name: tmpfs-shadow
+layout:
+  $SNAP/foo/bar:
+    bind: $SNAP_DATA/bar
+  /snap/tmpfs-shadow/42/foo:
+    type: tmpfs
+`
+	strk := NewScopedTracker()
+	info, err := InfoFromSnapYamlWithSideInfo([]byte(yaml1), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, ErrorMatches, `layout "\$SNAP/foo/bar" is shadowed by tmpfs layout "/snap/tmpfs-shadow/42/foo"`)
+
+	// Same for symlinks.
+	const yaml2 = `
+name: tmpfs-shadow
+layout:
+  $SNAP/foo/bar:
+    symlink: $SNAP_DATA/bar
+  /snap/tmpfs-shadow/42/foo:
+    type: tmpfs
+`
+	strk = NewScopedTracker()
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml2), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, ErrorMatches, `layout "\$SNAP/foo/bar" is shadowed by tmpfs layout "/snap/tmpfs-shadow/42/foo"`)
+
+	// Siblings that only share a name prefix are fine.
+	const yaml3 = `
+name: tmpfs-shadow
+layout:
+  $SNAP/foo-bar:
+    bind: $SNAP_DATA/bar
+  /snap/tmpfs-shadow/42/foo:
+    type: tmpfs
+`
+	strk = NewScopedTracker()
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml3), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	c.Assert(ValidateLayoutAll(info), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateLayoutAllMountPointKinds(c *C) {
+	// The same mount point, spelled differently, used as a file and a directory.
+	const yaml1 = `
+name: clashing-target-1
+layout:
+  $SNAP/foo:
+    bind: $SNAP_DATA/foo
+  /snap/clashing-target-1/42/foo:
+    bind-file: $SNAP_DATA/foo.conf
+`
+	strk := NewScopedTracker()
+	info, err := InfoFromSnapYamlWithSideInfo([]byte(yaml1), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, ErrorMatches, `layout "/snap/clashing-target-1/42/foo" uses "/snap/clashing-target-1/42/foo" as a file but layout "\$SNAP/foo" uses it as a directory`)
+
+	// A bind mount source that is a file mount point elsewhere.
+	const yaml2 = `
+name: clashing-target-2
+layout:
+  $SNAP/foo.conf:
+    bind-file: $SNAP_DATA/foo.conf
+  /etc/foo:
+    bind: $SNAP/foo.conf
+`
+	strk = NewScopedTracker()
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml2), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	err = ValidateLayoutAll(info)
+	c.Assert(err, ErrorMatches, `layout "/etc/foo" uses "\$SNAP/foo.conf" as a directory but layout "\$SNAP/foo.conf" uses it as a file`)
+
+	// Consistent use is fine.
+	const yaml3 = `
+name: consistent-target
+layout:
+  $SNAP/foo.conf:
+    bind-file: $SNAP_DATA/foo.conf
+  /etc/foo.conf:
+    bind-file: $SNAP/foo.conf
+`
+	strk = NewScopedTracker()
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml3), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
+	c.Assert(ValidateLayoutAll(info), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateLayoutAllSymlinkLoops(c *C) {
+	// Two symlinks pointing at each other.
+	const yaml1 = `
+name: symlink-loop
+layout:
+  $SNAP/foo:
+    symlink: $SNAP/bar
+  /snap/symlink-loop/42/bar:
+    symlink: $SNAP/foo
+`
+	strk := NewScopedTracker()
+	info, err := InfoFromSnapYamlWithSideInfo([]byte(yaml1), &SideInfo{Revision: R(42)}, strk)
+	c.Assert(err, IsNil)
 	err = ValidateLayoutAll(info)
-	c.Assert(err, ErrorMatches, `layout "/etc/norf" refers to file "\$SNAP/etc/norf" but another layout treats it as a directory`)
+	c.Assert(err, ErrorMatches, `layout "\$SNAP/foo" is a symlink to symlink layout "/snap/symlink-loop/42/bar" and may create a loop`)
 
-	// Same source path referred from a bind mount and symlink doesn't clash.
-	const yaml10 = `
-name: non-clashing-source-1
+	// A chain of symlinks is flagged too.
+	const yaml2 = `
+name: symlink-chain
 layout:
-  /etc/norf:
-    bind: $SNAP/etc/norf
-  /etc/corge:
-    symlink: $SNAP/etc/norf
+  /etc/foo:
+    symlink: $SNAP/foo
+  $SNAP/foo:
+    symlink: $SNAP_DATA/foo
 `
-
 	strk = NewScopedTracker()
-	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml10), &SideInfo{Revision: R(42)}, strk)
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml2), &SideInfo{Revision: R(42)}, strk)
 	c.Assert(err, IsNil)
-	c.Assert(info.Layout, HasLen, 2)
 	err = ValidateLayoutAll(info)
-	c.Assert(err, IsNil)
+	c.Assert(err, ErrorMatches, `layout "/etc/foo" is a symlink to symlink layout "\$SNAP/foo" and may create a loop`)
 
-	// Same source path referred from a file bind mount and symlink doesn't clash.
-	const yaml11 = `
-name: non-clashing-source-1
+	// Symlinks to other kinds of layouts are fine.
+	const yaml3 = `
+name: symlink-ok
 layout:
-  /etc/norf:
-    bind-file: $SNAP/etc/norf
-  /etc/corge:
-    symlink: $SNAP/etc/norf
+  /etc/foo:
+    symlink: $SNAP/foo
+  $SNAP/foo:
+    bind: $SNAP_DATA/foo
 `
-
 	strk = NewScopedTracker()
-	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml11), &SideInfo{Revision: R(42)}, strk)
-	c.Assert(err, IsNil)
-	c.Assert(info.Layout, HasLen, 2)
-	err = ValidateLayoutAll(info)
+	info, err = InfoFromSnapYamlWithSideInfo([]byte(yaml3), &SideInfo{Revision: R(42)}, strk)
 	c.Assert(err, IsNil)
+	c.Assert(ValidateLayoutAll(info), IsNil)
 }
 
 func (s *YamlSuite) TestValidateAppStartupOrder(c *C) {
@@ -1027,6 +2525,14 @@ apps:
     after: [foo]
     daemon: forking
   bar:
+`)
+	fooBeforeSelf := []byte(`
+apps:
+  foo:
+    before: [bar, foo]
+    daemon: simple
+  bar:
+    daemon: simple
 `)
 	// cycle between foo and bar
 	badOrder1 := []byte(`
@@ -1067,6 +2573,39 @@ apps:
    daemon: forking
  zed:
    daemon: forking
+`)
+	oneshotAfterOneshot := []byte(`
+apps:
+ foo:
+   after: [bar]
+   daemon: oneshot
+ bar:
+   daemon: oneshot
+`)
+	oneshotAfterTimer := []byte(`
+apps:
+ foo:
+   after: [bar]
+   daemon: oneshot
+   timer: 10:00-12:00
+ bar:
+   before: [baz]
+   daemon: oneshot
+   timer: mon,10:00
+ baz:
+   daemon: simple
+`)
+	fooAfterOtherSnap := []byte(`
+apps:
+ foo:
+   after: [other-snap.bar]
+   daemon: forking
+`)
+	fooBeforeOtherSnap := []byte(`
+apps:
+ foo:
+   before: [other-snap/bar]
+   daemon: forking
 `)
 	goodOrder1 := []byte(`
 apps:
@@ -1076,11 +2615,13 @@ apps:
  bar:
    before: [foo]
    daemon: dbus
+   bus-name: org.example.bar
  baz:
    after: [foo]
    daemon: forking
  zed:
    daemon: dbus
+   bus-name: org.example.zed
 `)
 	goodOrder2 := []byte(`
 apps:
@@ -1090,10 +2631,12 @@ apps:
  bar:
    before: [baz]
    daemon: dbus
+   bus-name: org.example.bar
  baz:
    daemon: forking
  zed:
    daemon: dbus
+   bus-name: org.example.zed
    after: [foo, bar, baz]
 `)
 
@@ -1109,6 +2652,14 @@ apps:
 		name: "foo before baz",
 		desc: fooBeforeBaz,
 		err:  `invalid definition of application "foo": before/after references a missing application "baz"`,
+	}, {
+		name: "foo after app of other snap",
+		desc: fooAfterOtherSnap,
+		err:  `invalid definition of application "foo": before/after references "other-snap.bar", ordering against applications of other snaps is not supported`,
+	}, {
+		name: "foo before app of other snap",
+		desc: fooBeforeOtherSnap,
+		err:  `invalid definition of application "foo": before/after references "other-snap/bar", ordering against applications of other snaps is not supported`,
 	}, {
 		name: "foo not a daemon",
 		desc: fooNotADaemon,
@@ -1120,15 +2671,15 @@ apps:
 	}, {
 		name: "bad order 1",
 		desc: badOrder1,
-		err:  `applications are part of a before/after cycle: (foo, bar)|(bar, foo)`,
+		err:  `applications are part of a before/after cycle: bar -> foo -> bar`,
 	}, {
 		name: "bad order 2",
 		desc: badOrder2,
-		err:  `applications are part of a before/after cycle: ((foo|bar|baz)(, )?){3}`,
+		err:  `applications are part of a before/after cycle: bar -> baz -> foo -> bar`,
 	}, {
 		name: "bad order 3 - cycle",
 		desc: badOrder3Cycle,
-		err:  `applications are part of a before/after cycle: ((foo|bar|baz|zed)(, )?){4}`,
+		err:  `applications are part of a before/after cycle: bar -> baz -> zed -> foo -> bar`,
 	}, {
 		name: "all good, 3 apps",
 		desc: goodOrder1,
@@ -1136,10 +2687,20 @@ apps:
 		name: "all good, 4 apps",
 		desc: goodOrder2,
 	}, {
-		name: "self cycle",
+		name: "oneshot after oneshot",
+		desc: oneshotAfterOneshot,
+	}, {
+		name: "oneshots with timers",
+		desc: oneshotAfterTimer,
+	}, {
+		name: "after self",
 		desc: fooSelfCycle,
-		err:  `applications are part of a before/after cycle: foo`},
-	}
+		err:  `invalid definition of application "foo": before/after references the application itself`,
+	}, {
+		name: "before self",
+		desc: fooBeforeSelf,
+		err:  `invalid definition of application "foo": before/after references the application itself`,
+	}}
 	for _, tc := range tcs {
 		c.Logf("trying %q", tc.name)
 		info, err := InfoFromSnapYaml(append(meta, tc.desc...))
@@ -1155,16 +2716,135 @@ apps:
 }
 
 func (s *ValidateSuite) TestValidateAppWatchdogTimeout(c *C) {
-	s.testValidateAppTimeout(c, "watchdog")
+	s.testValidateAppTimeout(c, "watchdog", "notify")
 }
 func (s *ValidateSuite) TestValidateAppStartTimeout(c *C) {
-	s.testValidateAppTimeout(c, "start")
+	s.testValidateAppTimeout(c, "start", "simple")
 }
 func (s *ValidateSuite) TestValidateAppStopTimeout(c *C) {
-	s.testValidateAppTimeout(c, "stop")
+	s.testValidateAppTimeout(c, "stop", "simple")
+}
+
+func (s *ValidateSuite) TestValidateAppTimeoutMinimum(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0.0
+apps:
+  start:
+    daemon: simple
+    start-timeout: 999ms
+  stop:
+    daemon: simple
+    stop-timeout: 1ms
+  default:
+    daemon: simple
+  minimum:
+    daemon: simple
+    start-timeout: 1s
+    stop-timeout: 1s
+  watchdog:
+    daemon: notify
+    watchdog-timeout: 500ms
+`))
+	c.Assert(err, IsNil)
+
+	// short timeouts are not errors, snaps using them kept working
+	warnings, err := ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	// zero still means the default, the minimum itself is fine and the
+	// watchdog is not affected
+	c.Check(warnings, DeepEquals, []string{
+		`application "start": start-timeout 999ms is shorter than 1s, the service may be killed before it gets a chance to run`,
+		`application "stop": stop-timeout 1ms is shorter than 1s, the service may be killed before it gets a chance to run`,
+	})
+}
+
+func (s *ValidateSuite) TestValidateAppTimeoutMinimumOverride(c *C) {
+	old := MinimumTimeout
+	defer func() { MinimumTimeout = old }()
+
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0.0
+apps:
+  foo:
+    daemon: simple
+    stop-timeout: 20ms
+`))
+	c.Assert(err, IsNil)
+
+	MinimumTimeout = timeout.Timeout(10 * time.Millisecond)
+	warnings, err := ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, HasLen, 0)
+	MinimumTimeout = timeout.Timeout(5 * time.Second)
+	warnings, err = ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, DeepEquals, []string{
+		`application "foo": stop-timeout 20ms is shorter than 5s, the service may be killed before it gets a chance to run`,
+	})
+	MinimumTimeout = 0
+	warnings, err = ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, HasLen, 0)
+}
+
+func (s *ValidateSuite) TestValidateAppWatchdogTimeoutRequiresNotify(c *C) {
+	for _, daemon := range []string{"simple", "forking", "oneshot", "dbus"} {
+		app := &AppInfo{Name: "foo", Daemon: daemon, BusName: "org.example.foo", WatchdogTimeout: timeout.Timeout(12 * time.Second)}
+		c.Check(ValidateApp(app), ErrorMatches, fmt.Sprintf(`watchdog-timeout requires "daemon: notify", not %q`, daemon))
+	}
+}
+
+func (s *ValidateSuite) TestValidateAppDaemonFieldCombinations(c *C) {
+	dbusSlot := &SlotInfo{Name: "dbus-slot", Interface: "dbus"}
+	info := &Info{Slots: map[string]*SlotInfo{"dbus-slot": dbusSlot}}
+	dbusSlot.Snap = info
+
+	for _, t := range []struct {
+		field string
+		set   func(app *AppInfo)
+		// daemons lists the kinds of daemons the field can be used with
+		daemons []string
+		err     string
+	}{
+		{"daemon-scope", func(app *AppInfo) { app.DaemonScope = UserDaemon },
+			[]string{"simple", "forking", "oneshot", "dbus", "notify"}, `"daemon-scope" can only be set for daemons`},
+		{"stop-mode", func(app *AppInfo) { app.StopMode = "sigterm" },
+			[]string{"simple", "forking", "oneshot", "dbus", "notify"}, `"stop-mode" cannot be used for "foo", only for services`},
+		{"refresh-mode", func(app *AppInfo) { app.RefreshMode = "endure" },
+			[]string{"simple", "forking", "oneshot", "dbus", "notify"}, `"refresh-mode" cannot be used for "foo", only for services`},
+		{"post-stop-command", func(app *AppInfo) { app.PostStopCommand = "bin/cleanup" },
+			[]string{"simple", "forking", "oneshot", "dbus", "notify"}, `"post-stop-command" cannot be used for "foo", only for services`},
+		{"install-mode", func(app *AppInfo) { app.InstallMode = "disable" },
+			[]string{"simple", "forking", "oneshot", "dbus", "notify"}, `"install-mode" cannot be used for "foo", only for services`},
+		{"reload-command", func(app *AppInfo) { app.ReloadCommand = "bin/reload" },
+			[]string{"simple", "forking", "dbus", "notify"}, `"reload-command" cannot be used for "foo", only for long-running services .*`},
+		{"watchdog-timeout", func(app *AppInfo) { app.WatchdogTimeout = timeout.Timeout(12 * time.Second) },
+			[]string{"notify"}, `watchdog-timeout requires "daemon: notify", not "(simple|forking|oneshot|dbus)"`},
+		{"activates-on", func(app *AppInfo) { app.ActivatesOn = []*SlotInfo{dbusSlot} },
+			[]string{"dbus"}, `invalid activates-on value "dbus-slot": only applicable to "dbus" daemons`},
+	} {
+		for _, daemon := range []string{"simple", "forking", "oneshot", "dbus", "notify"} {
+			app := &AppInfo{Snap: info, Name: "foo", Command: "bin/foo", Daemon: daemon, BusName: "org.example.foo"}
+			t.set(app)
+			if strutil.ListContains(t.daemons, daemon) {
+				c.Check(ValidateApp(app), IsNil, Commentf("%s / %s", t.field, daemon))
+			} else {
+				c.Check(ValidateApp(app), ErrorMatches, t.err, Commentf("%s / %s", t.field, daemon))
+			}
+		}
+		// nothing in the matrix applies to apps that are not daemons
+		app := &AppInfo{Snap: info, Name: "foo", Command: "bin/foo"}
+		t.set(app)
+		c.Check(ValidateApp(app), NotNil, Commentf("%s / not a daemon", t.field))
+	}
+
+	// the other combinations
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "dbus"}), ErrorMatches, `"bus-name" must be set for "dbus" daemons`)
+	c.Check(ValidateApp(&AppInfo{Name: "foo", Daemon: "simple", RefreshMode: "endure", RestartCond: RestartAlways}), ErrorMatches,
+		`"refresh-mode: endure" cannot be combined with "restart-condition: always" for "foo"`)
 }
 
-func (s *ValidateSuite) testValidateAppTimeout(c *C, timeout string) {
+func (s *ValidateSuite) testValidateAppTimeout(c *C, timeout, daemon string) {
 	timeout += "-timeout"
 	meta := []byte(`
 name: foo
@@ -1173,9 +2853,9 @@ version: 1.0
 	fooAllGood := []byte(fmt.Sprintf(`
 apps:
   foo:
-    daemon: simple
+    daemon: %s
     %s: 12s
-`, timeout))
+`, daemon, timeout))
 	fooNotADaemon := []byte(fmt.Sprintf(`
 apps:
   foo:
@@ -1185,9 +2865,9 @@ apps:
 	fooNegative := []byte(fmt.Sprintf(`
 apps:
   foo:
-    daemon: simple
+    daemon: %s
     %s: -12s
-`, timeout))
+`, daemon, timeout))
 
 	tcs := []struct {
 		name string
@@ -1220,6 +2900,14 @@ apps:
 	}
 }
 
+func (s *ValidateSuite) TestValidateTimer(c *C) {
+	for _, schedule := range []string{"10:00-12:00", "mon,10:00", "mon-fri,8:00~9:00/2", "mon1,12:15"} {
+		c.Check(ValidateTimer(schedule), IsNil, Commentf(schedule))
+	}
+	c.Check(ValidateTimer(""), ErrorMatches, `timer has invalid format: .*`)
+	c.Check(ValidateTimer("mon,10:00-12:00,mon2-wed3"), ErrorMatches, `timer has invalid format: cannot parse "mon2-wed3": invalid schedule fragment`)
+}
+
 func (s *YamlSuite) TestValidateAppTimer(c *C) {
 	meta := []byte(`
 name: foo
@@ -1242,6 +2930,25 @@ apps:
     daemon: oneshot
     timer: mon,10:00-12:00,mon2-wed3
 `)
+	forkingTimer := []byte(`
+apps:
+  foo:
+    daemon: forking
+    timer: 10:00-12:00
+`)
+	dbusTimer := []byte(`
+apps:
+  foo:
+    daemon: dbus
+    bus-name: org.example.foo
+    timer: mon,10:00
+`)
+	notifyTimer := []byte(`
+apps:
+  foo:
+    daemon: notify
+    timer: 10:00
+`)
 
 	tcs := []struct {
 		name string
@@ -1258,6 +2965,18 @@ apps:
 		name: "invalid timer",
 		desc: badTimer,
 		err:  `timer has invalid format: cannot parse "mon2-wed3": invalid schedule fragment`,
+	}, {
+		name: "forking daemon",
+		desc: forkingTimer,
+		err:  `timer "10:00-12:00" cannot be used with "forking" daemons, only with simple or oneshot ones`,
+	}, {
+		name: "dbus daemon",
+		desc: dbusTimer,
+		err:  `timer "mon,10:00" cannot be used with "dbus" daemons, only with simple or oneshot ones`,
+	}, {
+		name: "notify daemon",
+		desc: notifyTimer,
+		err:  `timer "10:00" cannot be used with "notify" daemons, only with simple or oneshot ones`,
 	}}
 	for _, tc := range tcs {
 		c.Logf("trying %q", tc.name)
@@ -1317,6 +3036,22 @@ base: foo_abc
 	c.Check(err, ErrorMatches, `base cannot specify a snap instance name: "foo_abc"`)
 }
 
+func (s *ValidateSuite) TestValidateBaseSelf(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+base: foo
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `snap "foo" cannot use itself as base`)
+
+	// the instance key does not matter
+	info.InstanceKey = "bar"
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `snap "foo" cannot use itself as base`)
+}
+
 func (s *ValidateSuite) TestValidateBaseCannotHaveBase(c *C) {
 	info, err := InfoFromSnapYaml([]byte(`name: foo
 version: 1.0
@@ -1339,6 +3074,209 @@ base: none
 	c.Assert(Validate(info), IsNil)
 }
 
+func (s *ValidateSuite) TestValidateTypeConstraintsKernelBase(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+type: kernel
+base: core18
+`))
+	c.Assert(err, IsNil)
+
+	err = Validate(info)
+	c.Check(err, ErrorMatches, `cannot have "base" field set to "core18" on "kernel" snap "foo"`)
+
+	info.Base = "none"
+	c.Check(Validate(info), IsNil)
+
+	// gadgets can have a base
+	info.Base = "core18"
+	info.SnapType = TypeGadget
+	c.Check(Validate(info), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateWithWarningsKernelGadgetServices(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0.0
+type: gadget
+apps:
+  svc2:
+    command: bin/svc2
+    daemon: simple
+  svc1:
+    command: bin/svc1
+    daemon: oneshot
+  cmd:
+    command: bin/cmd
+`))
+	c.Assert(err, IsNil)
+
+	warnings, err := ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, DeepEquals, []string{`"gadget" snap "foo" declares services: svc1, svc2`})
+
+	info.SnapType = TypeKernel
+	warnings, err = ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, DeepEquals, []string{`"kernel" snap "foo" declares services: svc1, svc2`})
+
+	// other types can have services
+	info.SnapType = TypeApp
+	warnings, err = ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, HasLen, 0)
+
+	info.SnapType = TypeGadget
+	delete(info.Apps, "svc1")
+	delete(info.Apps, "svc2")
+	warnings, err = ValidateWithWarnings(info)
+	c.Assert(err, IsNil)
+	c.Check(warnings, HasLen, 0)
+}
+
+func (s *ValidateSuite) TestValidateWithWarningsEpoch(c *C) {
+	for _, typ := range []string{"os", "base", "kernel"} {
+		info, err := InfoFromSnapYaml([]byte(fmt.Sprintf(`name: foo
+version: 1.0.0
+type: %s
+epoch: 1*
+`, typ)))
+		c.Assert(err, IsNil)
+		warnings, err := ValidateWithWarnings(info)
+		c.Assert(err, IsNil)
+		c.Check(warnings, DeepEquals, []string{
+			fmt.Sprintf(`%q snap "foo" declares epoch 1*, snaps depending on it may break`, typ),
+		})
+
+		// no warning for the default epoch
+		info.Epoch = Epoch{}
+		warnings, err = ValidateWithWarnings(info)
+		c.Assert(err, IsNil)
+		c.Check(warnings, HasLen, 0)
+	}
+
+	// other types can bump their epoch freely
+	for _, typ := range []string{"app", "gadget", "snapd"} {
+		info, err := InfoFromSnapYaml([]byte(fmt.Sprintf("name: foo\nversion: 1.0.0\ntype: %s\nepoch: 2\n", typ)))
+		c.Assert(err, IsNil)
+		warnings, err := ValidateWithWarnings(info)
+		c.Assert(err, IsNil)
+		c.Check(warnings, HasLen, 0, Commentf(typ))
+	}
+}
+
+func (s *ValidateSuite) TestValidateAssumes(c *C) {
+	for _, flag := range []string{"snapd2", "snapd2.45", "snapd2.45.1", "common-data-dir", "command-chain", "snapdnono", "feature1"} {
+		info := &Info{Assumes: []string{flag}}
+		c.Check(ValidateAssumes(info), IsNil, Commentf(flag))
+	}
+
+	for _, flag := range []string{"snapd", "snapd 2.45", "snapd2.", "snapd2.45.1.1", "snapd02.45", "snapd2.45nono", "snapd>=2.45"} {
+		info := &Info{Assumes: []string{flag}}
+		c.Check(ValidateAssumes(info), ErrorMatches, fmt.Sprintf(`invalid assumes entry %q: snapd version requirements must be of the form snapdX\[\.Y\[\.Z\]\], e.g. "snapd2.45"`, flag), Commentf(flag))
+	}
+
+	for _, flag := range []string{"", "Feature", "common_data_dir", "common-data-dir-", "-feature", "2.45", "feature 1"} {
+		info := &Info{Assumes: []string{flag}}
+		c.Check(ValidateAssumes(info), ErrorMatches, fmt.Sprintf(`invalid assumes entry %q: must be a feature name made of lowercase letters, digits and dashes, or a snapd version requirement like "snapd2.45"`, flag), Commentf(flag))
+	}
+
+	info := &Info{Assumes: []string{"command-chain", "snapd 2.45", "Feature"}}
+	c.Check(ValidateAssumes(info), ErrorMatches, `invalid assumes entry "snapd 2.45": .*`)
+}
+
+func (s *ValidateSuite) TestValidateAssumesViaValidate(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+assumes: [command-chain, snapd 2.45]
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `invalid assumes entry "snapd 2.45": snapd version requirements must be of the form .*`)
+
+	errs, warnings := ValidateWithConfig(info, ValidationConfig{
+		Severities: map[ValidationCheck]Severity{
+			CheckAssumes: SeverityWarning,
+		},
+	})
+	c.Check(errs, HasLen, 0)
+	c.Check(warnings, HasLen, 1)
+	c.Check(warnings[0], Matches, `invalid assumes entry "snapd 2.45": .*`)
+}
+
+func (s *ValidateSuite) TestValidateWithWarningsUnknownAssumes(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+assumes: [snapd2.45, command-chain, common-data-dir, feature-from-the-future, Garbled]
+`))
+	c.Assert(err, IsNil)
+
+	// the garbled entry is reported as an error, not as a warning
+	warnings, err := ValidateWithWarnings(info)
+	c.Check(err, ErrorMatches, `invalid assumes entry "Garbled": .*`)
+	c.Check(warnings, DeepEquals, []string{
+		`assumes entry "feature-from-the-future" is not a feature known to this version of snapd`,
+	})
+
+	info.Assumes = []string{"snapd2.45", "command-chain", "common-data-dir"}
+	warnings, err = ValidateWithWarnings(info)
+	c.Check(err, IsNil)
+	c.Check(warnings, HasLen, 0)
+}
+
+func (s *ValidateSuite) TestValidateCommonIDsFormat(c *C) {
+	for _, id := range []string{"org.foo", "org.foo.Bar", "io.github.foo_bar", "org.foo-bar.baz", "org.gnome.Calculator.desktop", "com.123.foo"} {
+		info := &Info{Apps: map[string]*AppInfo{"foo": {Name: "foo", CommonID: id}}}
+		c.Check(ValidateCommonIDs(info), IsNil, Commentf(id))
+	}
+	for _, id := range []string{"foo", ".org.foo", "org.foo.", "org..foo", "org.foo bar", "org/foo.bar", "org.föo", "org.foo:bar"} {
+		info := &Info{Apps: map[string]*AppInfo{"foo": {Name: "foo", CommonID: id}}}
+		c.Check(ValidateCommonIDs(info), ErrorMatches, fmt.Sprintf(`application "foo" common-id %q must be a reverse-DNS style AppStream component ID \(e.g. org.example.foo\)`, id), Commentf(id))
+	}
+}
+
+func (s *ValidateSuite) TestAppDesktop(c *C) {
+	for _, desktop := range []string{"", "foo.desktop", "foo-bar.desktop", "Foo2.desktop"} {
+		c.Check(ValidateApp(&AppInfo{Name: "foo", Desktop: desktop}), IsNil, Commentf(desktop))
+	}
+	for _, tc := range []struct {
+		desktop string
+		err     string
+	}{
+		{"gui/foo.desktop", `invalid desktop file name "gui/foo.desktop": cannot contain path separators`},
+		{"../foo.desktop", `invalid desktop file name "../foo.desktop": cannot contain path separators`},
+		{"foo", `invalid desktop file name "foo": must end in .desktop`},
+		{"foo.desktop.in", `invalid desktop file name "foo.desktop.in": must end in .desktop`},
+		{".desktop", `invalid desktop file name ".desktop": "" must be a valid app name`},
+		{"snap_foo.desktop", `invalid desktop file name "snap_foo.desktop": "snap_foo" must be a valid app name`},
+		{"foo.bar.desktop", `invalid desktop file name "foo.bar.desktop": "foo.bar" must be a valid app name`},
+	} {
+		c.Check(ValidateApp(&AppInfo{Name: "foo", Desktop: tc.desktop}), ErrorMatches, tc.err)
+	}
+}
+
+func (s *ValidateSuite) TestValidateDesktopCollisions(c *C) {
+	info, err := InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    desktop: foo.desktop
+  bar:
+    desktop: bar.desktop
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), IsNil)
+
+	info, err = InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+apps:
+  foo:
+    desktop: foo.desktop
+  bar:
+    desktop: foo.desktop
+`))
+	c.Assert(err, IsNil)
+	c.Check(Validate(info), ErrorMatches, `application "foo" desktop file "foo.desktop" must be unique, already used by application "bar"`)
+}
+
 func (s *ValidateSuite) TestValidateCommonIDs(c *C) {
 	meta := `
 name: foo
@@ -1391,6 +3329,17 @@ func (s *validateSuite) TestValidateDescription(c *C) {
 		c.Check(ValidateDescription(strings.Repeat(s, 2049)), ErrorMatches, `description can have up to 4096 codepoints, got 4098`)
 		c.Check(ValidateDescription(strings.Repeat(s, 2048)), IsNil)
 	}
+
+	c.Check(ValidateDescription("Multi-line\n\n\tdescription\n"), IsNil)
+	for _, t := range []struct{ descr, err string }{
+		{"foo\x1b[31mbar", `description cannot contain control character U\+001B, found at codepoint 3`},
+		{"🐧\r\n", `description cannot contain control character U\+000D, found at codepoint 1`},
+		{"\x00", `description cannot contain control character U\+0000, found at codepoint 0`},
+		{"ab\x7f", `description cannot contain control character U\+007F, found at codepoint 2`},
+		{"\u00e9\u0085", `description cannot contain control character U\+0085, found at codepoint 1`},
+	} {
+		c.Check(ValidateDescription(t.descr), ErrorMatches, t.err)
+	}
 }
 
 func (s *validateSuite) TestValidateTitle(c *C) {
@@ -1402,6 +3351,19 @@ func (s *validateSuite) TestValidateTitle(c *C) {
 		c.Check(ValidateTitle(strings.Repeat(s, 21)), ErrorMatches, `title can have up to 40 codepoints, got 42`)
 		c.Check(ValidateTitle(strings.Repeat(s, 20)), IsNil)
 	}
+
+	// unset is fine
+	c.Check(ValidateTitle(""), IsNil)
+	c.Check(ValidateTitle(" the title "), IsNil)
+	for _, s := range []string{
+		" ",
+		"\t\n",
+		"\u00a0\u3000",         // no-break and ideographic spaces
+		"\u200b\u200b",         // zero width spaces
+		" \u200d\ufeff\u00ad ", // zero width joiner, BOM, soft hyphen
+	} {
+		c.Check(ValidateTitle(s), ErrorMatches, `title must contain visible characters, got ".*"`, Commentf("%q", s))
+	}
 }
 
 func (s *validateSuite) TestValidatePlugSlotName(c *C) {
@@ -1461,6 +3423,32 @@ version: 1.0
 	}
 }
 
+func (s *ValidateSuite) TestValidateRestartCondition(c *C) {
+	for _, cond := range []string{"on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog", "always", "never", "no"} {
+		c.Check(ValidateRestartCondition(cond), IsNil, Commentf(cond))
+	}
+	for _, cond := range []string{"", "on-succes", "Always", "on-failure ", "yes"} {
+		c.Check(ValidateRestartCondition(cond), ErrorMatches, fmt.Sprintf(`invalid restart condition: %q`, cond))
+	}
+
+	// hand-built apps get the same checks
+	app := &AppInfo{Name: "foo", Daemon: "simple", RestartCond: RestartCondition("sometimes")}
+	c.Check(ValidateApp(app), ErrorMatches, `invalid restart condition: "sometimes"`)
+	app.RestartCond = RestartOnWatchdog
+	c.Check(ValidateApp(app), IsNil)
+}
+
+func (s *ValidateSuite) TestValidateAppRestartDelayMaxOverride(c *C) {
+	old := MaxRestartDelay
+	defer func() { MaxRestartDelay = old }()
+	MaxRestartDelay = timeout.Timeout(2 * time.Hour)
+
+	app := &AppInfo{Name: "foo", Daemon: "simple", RestartDelay: timeout.Timeout(100 * time.Minute)}
+	c.Check(ValidateApp(app), IsNil)
+	app.RestartDelay = timeout.Timeout(3 * time.Hour)
+	c.Check(ValidateApp(app), ErrorMatches, `restart-delay cannot be longer than 2h0m0s`)
+}
+
 func (s *ValidateSuite) TestValidateAppRestart(c *C) {
 	meta := []byte(`
 name: foo
@@ -1500,6 +3488,25 @@ apps:
     daemon: simple
     restart-delay: -12s
 `)
+	fooLongDelay := []byte(`
+apps:
+  foo:
+    daemon: simple
+    restart-delay: 100h
+`)
+	fooMaxDelay := []byte(`
+apps:
+  foo:
+    daemon: simple
+    restart-delay: 15m
+`)
+	fooDelayNever := []byte(`
+apps:
+  foo:
+    daemon: simple
+    restart-condition: never
+    restart-delay: 12s
+`)
 
 	tcs := []struct {
 		name string
@@ -1526,6 +3533,17 @@ apps:
 		name: "negative restart-delay",
 		desc: fooNegativeDelay,
 		err:  `restart-delay cannot be negative`,
+	}, {
+		name: "restart-delay at the maximum",
+		desc: fooMaxDelay,
+	}, {
+		name: "restart-delay too long",
+		desc: fooLongDelay,
+		err:  `restart-delay cannot be longer than 15m0s`,
+	}, {
+		name: "restart-delay with restart-condition never",
+		desc: fooDelayNever,
+		err:  `restart-delay cannot be used with restart-condition "never"`,
 	}}
 	for _, tc := range tcs {
 		c.Logf("trying %q", tc.name)
@@ -70,8 +70,27 @@ func newParser(r io.Reader) *parser {
 	return &parser{s: NewScanner(r)}
 }
 
+// LicenseError is returned when a license expression is invalid, it
+// tells where in the expression the problem was found.
+type LicenseError struct {
+	// Token is the offending token, it is empty if the expression
+	// ended prematurely.
+	Token string
+	// Offset is the byte offset of Token in the expression.
+	Offset int
+
+	Err error
+}
+
+func (e *LicenseError) Error() string {
+	return e.Err.Error()
+}
+
 func (p *parser) Validate() error {
-	return p.validate(0)
+	if err := p.validate(0); err != nil {
+		return &LicenseError{Token: p.s.Text(), Offset: p.s.Offset(), Err: err}
+	}
+	return nil
 }
 
 func (p *parser) advance(id string) error {
@@ -75,3 +75,28 @@ func (s *spdxSuite) TestParseError(c *C) {
 		c.Check(err, ErrorMatches, t.errStr, Commentf("input: %q", t.inp))
 	}
 }
+
+func (s *spdxSuite) TestParseErrorPosition(c *C) {
+	for _, t := range []struct {
+		inp    string
+		token  string
+		offset int
+	}{
+		{"FOO", "FOO", 0},
+		{"(MIT OR Apache-2.0) AND GPL~3.0+", "GPL~3.0+", 24},
+		{"(MIT OR  FOO) AND GPL-3.0+", "FOO", 9},
+		{"GPL-2.0 WITH BAR", "BAR", 13},
+		{"(GPL-2.0))", ")", 9},
+		{"MIT AND  OR GPL-2.0", "OR", 9},
+		{"GPL-2.0 OR ", "", 11},
+		{"(GPL-2.0", "", 8},
+		{"", "", 0},
+	} {
+		err := spdx.ValidateLicense(t.inp)
+		c.Assert(err, NotNil, Commentf("input: %q", t.inp))
+		licErr, ok := err.(*spdx.LicenseError)
+		c.Assert(ok, Equals, true, Commentf("input: %q", t.inp))
+		c.Check(licErr.Token, Equals, t.token, Commentf("input: %q", t.inp))
+		c.Check(licErr.Offset, Equals, t.offset, Commentf("input: %q", t.inp))
+	}
+}
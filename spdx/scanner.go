@@ -21,11 +21,17 @@ package spdx
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 )
 
 type Scanner struct {
 	*bufio.Scanner
+
+	// consumed is the number of bytes of input consumed so far
+	consumed int
+	// offset is the offset of the current token in the input
+	offset int
 }
 
 func spdxSplit(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -62,8 +68,26 @@ func spdxSplit(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return start, nil, nil
 }
 
+// split wraps spdxSplit to keep track of where tokens start.
+func (s *Scanner) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = spdxSplit(data, atEOF)
+	if token != nil {
+		s.offset = s.consumed + len(data) - len(bytes.TrimLeft(data, " \n"))
+	} else {
+		s.offset = s.consumed + advance
+	}
+	s.consumed += advance
+	return advance, token, err
+}
+
+// Offset returns the byte offset of the current token in the input,
+// once the input is exhausted it is the offset of its end.
+func (s *Scanner) Offset() int {
+	return s.offset
+}
+
 func NewScanner(r io.Reader) *Scanner {
-	scanner := bufio.NewScanner(r)
-	scanner.Split(spdxSplit)
-	return &Scanner{scanner}
+	s := &Scanner{Scanner: bufio.NewScanner(r)}
+	s.Scanner.Split(s.split)
+	return s
 }
@@ -47,3 +47,14 @@ func (s *spdxSuite) TestScannerHappy(c *C) {
 		c.Check(scanner.Err(), IsNil)
 	}
 }
+
+func (s *spdxSuite) TestScannerOffset(c *C) {
+	scanner := spdx.NewScanner(bytes.NewBufferString(" (MIT  OR\nGPL-2.0) "))
+	var offsets []int
+	for scanner.Scan() {
+		offsets = append(offsets, scanner.Offset())
+	}
+	c.Check(scanner.Err(), IsNil)
+	c.Check(offsets, DeepEquals, []int{1, 2, 7, 10, 17})
+	c.Check(scanner.Offset(), Equals, 19)
+}
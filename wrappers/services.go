@@ -547,7 +547,12 @@ X-Snappy=yes
 [Socket]
 Service={{.ServiceFileName}}
 FileDescriptorName={{.SocketInfo.Name}}
+{{- if .ListenStream}}
 ListenStream={{.ListenStream}}
+{{- end}}
+{{- if .ListenDatagram}}
+ListenDatagram={{.ListenDatagram}}
+{{- end}}
 {{- if .SocketInfo.SocketMode}}
 SocketMode={{.SocketInfo.SocketMode | printf "%04o"}}
 {{- end}}
@@ -559,7 +564,8 @@ WantedBy={{.SocketsTarget}}
 	t := template.Must(template.New("socket-wrapper").Parse(socketTemplate))
 
 	socket := appInfo.Sockets[socketName]
-	listenStream := renderListenStream(socket)
+	listenStream := renderListenAddress(socket, socket.ListenStream)
+	listenDatagram := renderListenAddress(socket, socket.ListenDatagram)
 	wrapperData := struct {
 		App             *snap.AppInfo
 		ServiceFileName string
@@ -568,6 +574,7 @@ WantedBy={{.SocketsTarget}}
 		SocketName      string
 		SocketInfo      *snap.SocketInfo
 		ListenStream    string
+		ListenDatagram  string
 	}{
 		App:             appInfo,
 		ServiceFileName: filepath.Base(appInfo.ServiceFile()),
@@ -576,6 +583,7 @@ WantedBy={{.SocketsTarget}}
 		SocketName:      socketName,
 		SocketInfo:      socket,
 		ListenStream:    listenStream,
+		ListenDatagram:  listenDatagram,
 	}
 
 	if err := t.Execute(&templateOut, wrapperData); err != nil {
@@ -598,9 +606,9 @@ func generateSnapSocketFiles(app *snap.AppInfo) (*map[string][]byte, error) {
 	return &socketFiles, nil
 }
 
-func renderListenStream(socket *snap.SocketInfo) string {
+func renderListenAddress(socket *snap.SocketInfo, address string) string {
 	snap := socket.App.Snap
-	listenStream := strings.Replace(socket.ListenStream, "$SNAP_DATA", snap.DataDir(), -1)
+	listenStream := strings.Replace(address, "$SNAP_DATA", snap.DataDir(), -1)
 	// TODO: when we support User/Group in the generated systemd unit,
 	// adjust this accordingly
 	serviceUserUid := sys.UserID(0)
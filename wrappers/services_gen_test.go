@@ -67,6 +67,30 @@ Type=%s
 WantedBy=multi-user.target
 `
 
+// oneshot services cannot be reloaded
+const expectedOneshotServiceFmt = `[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Service for snap application snap.app
+Requires=%s-snap-44.mount
+Wants=network.target
+After=%s-snap-44.mount network.target
+X-Snappy=yes
+
+[Service]
+ExecStart=/usr/bin/snap run snap.app
+SyslogIdentifier=snap.app
+Restart=no
+WorkingDirectory=/var/snap/snap/44
+ExecStop=/usr/bin/snap run --command=stop snap.app
+ExecStopPost=/usr/bin/snap run --command=post-stop snap.app
+TimeoutStopSec=10
+Type=oneshot
+RemainAfterExit=yes
+
+[Install]
+WantedBy=multi-user.target
+`
+
 var (
 	mountUnitPrefix = strings.Replace(dirs.SnapMountDir[1:], "/", "-", -1)
 )
@@ -74,7 +98,7 @@ var (
 var (
 	expectedAppService     = fmt.Sprintf(expectedServiceFmt, mountUnitPrefix, mountUnitPrefix, "on-failure", "simple")
 	expectedDbusService    = fmt.Sprintf(expectedServiceFmt, mountUnitPrefix, mountUnitPrefix, "on-failure", "dbus\nBusName=foo.bar.baz")
-	expectedOneshotService = fmt.Sprintf(expectedServiceFmt, mountUnitPrefix, mountUnitPrefix, "no", "oneshot\nRemainAfterExit=yes")
+	expectedOneshotService = fmt.Sprintf(expectedOneshotServiceFmt, mountUnitPrefix, mountUnitPrefix)
 )
 
 var (
@@ -258,7 +282,6 @@ apps:
     app:
         command: bin/start
         stop-command: bin/stop
-        reload-command: bin/reload
         post-stop-command: bin/stop --post
         stop-timeout: 10s
         daemon: oneshot
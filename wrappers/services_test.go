@@ -462,6 +462,43 @@ ListenStream=%s
 	c.Check(sock3File, testutil.FileContains, expected)
 }
 
+func (s *servicesTestSuite) TestAddSnapSocketFilesDatagram(c *C) {
+	info := snaptest.MockSnap(c, packageHello+`
+ svc1:
+  daemon: simple
+  plugs: [network-bind]
+  sockets:
+    sock1:
+      listen-datagram: $SNAP_COMMON/sock1.socket
+    sock2:
+      listen-datagram: 127.0.0.1:5353
+
+`, &snap.SideInfo{Revision: snap.R(12)})
+
+	sock1File := filepath.Join(s.tempdir, "/etc/systemd/system/snap.hello-snap.svc1.sock1.socket")
+	sock2File := filepath.Join(s.tempdir, "/etc/systemd/system/snap.hello-snap.svc1.sock2.socket")
+
+	err := wrappers.AddSnapServices(info, nil)
+	c.Assert(err, IsNil)
+
+	expected := fmt.Sprintf(
+		`[Socket]
+Service=snap.hello-snap.svc1.service
+FileDescriptorName=sock1
+ListenDatagram=%s
+
+`, filepath.Join(s.tempdir, "/var/snap/hello-snap/common/sock1.socket"))
+	c.Check(sock1File, testutil.FileContains, expected)
+
+	expected = `[Socket]
+Service=snap.hello-snap.svc1.service
+FileDescriptorName=sock2
+ListenDatagram=127.0.0.1:5353
+
+`
+	c.Check(sock2File, testutil.FileContains, expected)
+}
+
 func (s *servicesTestSuite) TestStartSnapMultiServicesFailStartCleanup(c *C) {
 	var sysdLog [][]string
 	svc1Name := "snap.hello-snap.svc1.service"
@@ -703,7 +740,7 @@ func (s *servicesTestSuite) TestServiceAfterBefore(c *C) {
 func (s *servicesTestSuite) TestServiceWatchdog(c *C) {
 	snapYaml := packageHello + `
  svc2:
-   daemon: forking
+   daemon: notify
    watchdog-timeout: 12s
  svc3:
    daemon: forking